@@ -1,50 +1,137 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
+	"sort"
+	"strings"
 	"time"
 
 	log "github.com/EntropyPool/entropy-logger"
 	authapi "github.com/NpoolDevOps/fbc-auth-service/authapi"
 	authtypes "github.com/NpoolDevOps/fbc-auth-service/types"
+	"github.com/NpoolDevOps/fbc-license-service/billing"
 	"github.com/NpoolDevOps/fbc-license-service/crypto"
+	"github.com/NpoolDevOps/fbc-license-service/fingerprint"
 	fbclib "github.com/NpoolDevOps/fbc-license-service/library"
 	fbcmysql "github.com/NpoolDevOps/fbc-license-service/mysql"
 	fbcredis "github.com/NpoolDevOps/fbc-license-service/redis"
+	"github.com/NpoolDevOps/fbc-license-service/reminder"
 	types "github.com/NpoolDevOps/fbc-license-service/types"
+	"github.com/NpoolDevOps/fbc-license-service/version"
+	"github.com/NpoolDevOps/fbc-license-service/webhook"
 	httpdaemon "github.com/NpoolRD/http-daemon"
 	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+type LicenseConfig struct {
+	GraceDays    int    `json:"grace_days"`
+	WarnDays     int    `json:"warn_days"`
+	KeyFile      string `json:"key_file"`
+	LeaseMinutes int    `json:"lease_minutes"`
+}
+
+const defaultLeaseMinutes = 30
+
+type PresenceConfig struct {
+	ReapSeconds    int `json:"reap_seconds"`
+	OfflineMinutes int `json:"offline_minutes"`
+}
+
+const (
+	defaultReapSeconds    = 60
+	defaultOfflineMinutes = 10
+)
+
+type TrialConfig struct {
+	Enable bool `json:"enable"`
+	Quota  int  `json:"quota"`
+	Days   int  `json:"days"`
+}
+
+type FingerprintConfig struct {
+	Threshold int `json:"threshold"`
+}
+
+const defaultFingerprintThreshold = 3
+
+type CloneConfig struct {
+	WindowSeconds int    `json:"window_seconds"`
+	Policy        string `json:"policy"`
+}
+
+const (
+	ClonePolicyAlert   = "alert"
+	ClonePolicyStop    = "stop"
+	ClonePolicyDisable = "disable"
+
+	defaultCloneWindowSeconds = 600
+	cloneFlagDuration         = 24 * time.Hour
 )
 
 type AuthServerConfig struct {
-	RedisCfg fbcredis.RedisConfig `json:"redis"`
-	MysqlCfg fbcmysql.MysqlConfig `json:"mysql"`
-	Port     int                  `json:"port"`
+	RedisCfg       fbcredis.RedisConfig    `json:"redis"`
+	MysqlCfg       fbcmysql.MysqlConfig    `json:"mysql"`
+	LicenseCfg     LicenseConfig           `json:"license"`
+	PresenceCfg    PresenceConfig          `json:"presence"`
+	WebhookCfg     webhook.WebhookConfig   `json:"webhook"`
+	ReminderCfg    reminder.ReminderConfig `json:"reminder"`
+	TrialCfg       TrialConfig             `json:"trial"`
+	FingerprintCfg FingerprintConfig       `json:"fingerprint"`
+	CloneCfg       CloneConfig             `json:"clone"`
+	TrustedProxies []string                `json:"trusted_proxies"`
+	Port           int                     `json:"port"`
 }
 
+const (
+	defaultCommandExpireMinutes = 24 * 60
+	maxCommandExpireMinutes     = 30 * 24 * 60
+)
+
+const maxMaintenanceDuration = 7 * 24 * time.Hour
+
 type AuthServer struct {
 	config      AuthServerConfig
 	authText    string
+	licenseKey  *crypto.RsaCrypto
 	redisClient *fbcredis.RedisCli
 	mysqlClient *fbcmysql.MysqlCli
+	notifier    *webhook.Notifier
+	scheduler   *reminder.Scheduler
+	proxies     []*net.IPNet
 }
 
-func NewAuthServer(configFile string) *AuthServer {
+func readAuthServerConfig(configFile string) (*AuthServerConfig, error) {
 	buf, err := ioutil.ReadFile(configFile)
 	if err != nil {
-		log.Errorf(log.Fields{}, "cannot read file %v: %v", configFile, err)
-		return nil
+		return nil, err
 	}
 
 	config := AuthServerConfig{}
 	err = json.Unmarshal(buf, &config)
 	if err != nil {
-		log.Errorf(log.Fields{}, "cannot parse file %v: %v", configFile, err)
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+func NewAuthServer(configFile string) *AuthServer {
+	cfg, err := readAuthServerConfig(configFile)
+	if err != nil {
+		log.Errorf(log.Fields{}, "cannot load config %v: %v", configFile, err)
 		return nil
 	}
+	config := *cfg
 
 	log.Infof(log.Fields{}, "create redis cli: %v", config.RedisCfg)
 	redisCli := fbcredis.NewRedisCli(config.RedisCfg)
@@ -60,11 +147,35 @@ func NewAuthServer(configFile string) *AuthServer {
 		return nil
 	}
 
+	licenseKey, err := loadLicenseKey(config.LicenseCfg.KeyFile)
+	if err != nil {
+		log.Errorf(log.Fields{}, "cannot load license key %v: %v", config.LicenseCfg.KeyFile, err)
+		return nil
+	}
+
+	proxies, err := parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		log.Errorf(log.Fields{}, "cannot parse trusted proxies: %v", err)
+		return nil
+	}
+
+	err = mysqlCli.SeedRevocations()
+	if err != nil {
+		log.Errorf(log.Fields{}, "cannot seed revocations: %v", err)
+		return nil
+	}
+
+	notifier := webhook.NewNotifier(config.WebhookCfg, mysqlCli)
+
 	server := &AuthServer{
 		config:      config,
 		authText:    fbclib.FBCAuthText,
+		licenseKey:  licenseKey,
 		redisClient: redisCli,
 		mysqlClient: mysqlCli,
+		notifier:    notifier,
+		scheduler:   reminder.NewScheduler(config.ReminderCfg, mysqlCli, notifier),
+		proxies:     proxies,
 	}
 
 	log.Infof(log.Fields{}, "successful to create auth server")
@@ -72,6 +183,38 @@ func NewAuthServer(configFile string) *AuthServer {
 	return server
 }
 
+// loadLicenseKey never creates the key, a missing key file must fail the start
+// instead of silently signing with a key no client trusts. Run the keygen
+// command to create it.
+func loadLicenseKey(keyFile string) (*crypto.RsaCrypto, error) {
+	buf, err := ioutil.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		return nil, xerrors.Errorf("%v does not exist, create it with the keygen command", keyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return crypto.NewRsaCryptoWithPrivkey(buf)
+}
+
+// generateLicenseKey writes a new license key to keyFile, an existing key is
+// never overwritten since every license signed by it would become invalid
+func generateLicenseKey(keyFile string, bits int) (*crypto.RsaCrypto, error) {
+	file, err := os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	key := crypto.NewRsaCrypto(bits)
+	_, err = file.Write(key.GetPrivkey())
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
 func (s *AuthServer) Run() error {
 	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
 		Location: types.ExchangeKeyAPI,
@@ -137,11 +280,262 @@ func (s *AuthServer) Run() error {
 		},
 	})
 
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.IssueLicenseAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.IssueLicenseRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.UpdateEntitlementAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.UpdateEntitlementRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.EntitlementsAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.EntitlementsRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.CreatePlanAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.CreatePlanRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.UpdatePlanAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.UpdatePlanRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.PlansAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.PlansRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.DeregisterClientAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.DeregisterClientRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.UpdateStatusAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.UpdateStatusRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.UptimeAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.UptimeRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.AuditsAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.AuditsRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.RevocationsAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.RevocationsRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.CreateActivationCodesAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.CreateActivationCodesRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.ActivationCodesAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.ActivationCodesRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.RedeemCodeAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.RedeemCodeRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.UsageAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.UsageRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.UpdateVersionPolicyAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.UpdateVersionPolicyRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.VersionPoliciesAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.VersionPoliciesRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.QueueCommandAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.QueueCommandRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.CommandsAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.CommandsRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.ScheduleMaintenanceAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.ScheduleMaintenanceRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.CancelMaintenanceAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.CancelMaintenanceRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.MaintenancesAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.MaintenancesRequest(w, req)
+		},
+	})
+
+	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
+		Location: types.BillingExportAPI,
+		Method:   "POST",
+		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+			return s.BillingExportRequest(w, req)
+		},
+	})
+
+	go s.presenceReaper()
+	go s.notifier.Run()
+	go s.scheduler.Run()
+
 	log.Infof(log.Fields{}, "start http daemon at %v", s.config.Port)
 	httpdaemon.Run(s.config.Port)
 	return nil
 }
 
+func (s *AuthServer) offlineDuration() time.Duration {
+	offlineMinutes := s.config.PresenceCfg.OfflineMinutes
+	if offlineMinutes <= 0 {
+		offlineMinutes = defaultOfflineMinutes
+	}
+	return time.Duration(offlineMinutes) * time.Minute
+}
+
+func (s *AuthServer) presenceReaper() {
+	reapSeconds := s.config.PresenceCfg.ReapSeconds
+	if reapSeconds <= 0 {
+		reapSeconds = defaultReapSeconds
+	}
+
+	ticker := time.NewTicker(time.Duration(reapSeconds) * time.Second)
+	for range ticker.C {
+		now := time.Now()
+		lastSeen := now.Add(-s.offlineDuration())
+		count, err := s.mysqlClient.ReapOfflineClients(lastSeen, now)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to reap offline clients: %v", err)
+			continue
+		}
+		if count > 0 {
+			log.Infof(log.Fields{}, "%v clients go offline", count)
+		}
+	}
+}
+
+// maintenanceCovers reports whether the window covers the client, a window
+// without clients covers every client of its user
+func maintenanceCovers(info types.MaintenanceInfo, clientInfo types.ClientInfo) bool {
+	if info.ClientUser != clientInfo.ClientUser {
+		return false
+	}
+	if len(info.ClientIds) == 0 {
+		return true
+	}
+	for _, id := range info.ClientIds {
+		if id == clientInfo.Id {
+			return true
+		}
+	}
+	return false
+}
+
+// activeMaintenance returns the open window covering the client which ends last
+func (s *AuthServer) activeMaintenance(clientInfo *types.ClientInfo, now time.Time) *types.MaintenanceInfo {
+	var active *types.MaintenanceInfo
+
+	infos := s.mysqlClient.QueryActiveMaintenanceInfos(clientInfo.ClientUser, now)
+	for i, info := range infos {
+		if !maintenanceCovers(info, *clientInfo) {
+			continue
+		}
+		if active == nil || active.EndTime.Before(info.EndTime) {
+			active = &infos[i]
+		}
+	}
+
+	return active
+}
+
 func (s *AuthServer) ExchangeKeyRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
@@ -185,6 +579,9 @@ func (s *AuthServer) ExchangeKeyRequest(w http.ResponseWriter, req *http.Request
 
 	err = s.redisClient.InsertKeyInfo("session", sessionId,
 		fbcredis.SessionInfo{
+			SessionId:    sessionId.String(),
+			Spec:         input.Spec,
+			Fingerprint:  input.Fingerprint,
 			MyPubKey:     myPubKey,
 			ClientPubKey: input.PublicKey,
 		}, 24*100000*time.Hour)
@@ -220,7 +617,7 @@ func (s *AuthServer) LoginRequest(w http.ResponseWriter, req *http.Request) (int
 
 	log.Infof(log.Fields{}, "login request from %v / %v", input.ClientUser, input.ClientPasswd)
 	myAppId := uuid.MustParse("00000001-0001-0001-0001-000000000001")
-	_, err = authapi.Login(authtypes.UserLoginInput{
+	login, err := authapi.Login(authtypes.UserLoginInput{
 		Username: input.ClientUser,
 		Password: input.ClientPasswd,
 		AppId:    myAppId,
@@ -230,101 +627,564 @@ func (s *AuthServer) LoginRequest(w http.ResponseWriter, req *http.Request) (int
 		return nil, err.Error(), -2
 	}
 
-	_, err = s.redisClient.QuerySession(input.SessionId)
+	sessionInfo, err := s.redisClient.QuerySession(input.SessionId)
 	if err != nil {
 		log.Errorf(log.Fields{}, "fail to query session: %v", err)
 		return nil, err.Error(), -4
 	}
 
-	_, err = s.mysqlClient.QueryUserInfoByUsername(input.ClientUser)
+	userInfo, err := s.mysqlClient.QueryUserInfoByUsername(input.ClientUser)
+	if err != nil && s.config.TrialCfg.Enable {
+		userInfo, err = s.createTrialUser(login.AuthCode, input.ClientUser)
+	}
 	if err != nil {
 		log.Errorf(log.Fields{}, "fail to find client user: %v", err)
 		return nil, err.Error(), -5
 	}
 
 	clientInfo, err := s.mysqlClient.QueryClientInfoByClientSn(input.ClientSN)
+	if err != nil && sessionInfo.Fingerprint != nil {
+		clientInfo, err = s.matchFingerprintClient(userInfo.Username, *sessionInfo.Fingerprint)
+		if err == nil {
+			log.Infof(log.Fields{}, "client %v change sn from %v to %v", clientInfo.Id, clientInfo.ClientSn, input.ClientSN)
+			err = s.mysqlClient.UpdateClientSn(clientInfo.Id, input.ClientSN)
+			if err != nil {
+				log.Errorf(log.Fields{}, "fail to update sn of client %v: %v", clientInfo.Id, err)
+				return nil, err.Error(), -13
+			}
+			before := *clientInfo
+			clientInfo.ClientSn = input.ClientSN
+			s.audit(req, &authtypes.UserInfoOutput{
+				Id:       userInfo.Id,
+				Username: userInfo.Username,
+			}, types.AuditRenameClient, clientInfo.Id.String(), before, *clientInfo)
+		}
+	}
 	if err != nil {
 		clientInfo = &types.ClientInfo{
-			Id:          uuid.New(),
-			ClientUser:  input.ClientUser,
-			ClientSn:    input.ClientSN,
-			NetworkType: input.NetworkType,
-			Status:      "online",
-			CreateTime:  time.Now(),
-			ModifyTime:  time.Now(),
-		}
-		err = s.mysqlClient.InsertClientInfo(*clientInfo)
+			Id:            uuid.New(),
+			ClientUser:    input.ClientUser,
+			ClientSn:      input.ClientSN,
+			NetworkType:   input.NetworkType,
+			ClientVersion: input.ClientVersion,
+			Status:        "online",
+			CreateTime:    time.Now(),
+			ModifyTime:    time.Now(),
+			LastSeen:      time.Now(),
+		}
+		newId := clientInfo.Id
+		clientInfo, err = s.mysqlClient.RegisterClientInfo(*clientInfo)
+		if err == fbcmysql.ErrQuotaExceeded {
+			log.Errorf(log.Fields{}, "fail to register client %v for %v: %v", input.ClientSN, input.ClientUser, err)
+			s.notifier.EmitOnce(types.EventQuotaExhausted, quotaEventKey(userInfo, ""), userInfo)
+			return nil, err.Error(), -8
+		}
+		if err == fbcmysql.ErrNetworkQuotaExceeded {
+			log.Errorf(log.Fields{}, "fail to register client %v for %v on %v: %v", input.ClientSN, input.ClientUser, input.NetworkType, err)
+			s.notifier.EmitOnce(types.EventQuotaExhausted, quotaEventKey(userInfo, input.NetworkType), userInfo)
+			return nil, err.Error(), -11
+		}
 		if err != nil {
 			log.Errorf(log.Fields{}, "fail to insert client info: %v", err)
 			return nil, err.Error(), -6
 		}
-	} else {
-		if clientInfo.ClientUser != input.ClientUser {
-			return nil, "registered user and client report user is not equal", -7
+		if clientInfo.Id == newId {
+			s.notifier.EmitOnce(types.EventClientRegistered, clientInfo.Id.String(), clientInfo)
+			if userInfo.LicenseMode != fbcmysql.LicenseModeFloating &&
+				userInfo.Quota <= s.mysqlClient.QueryClientCount(userInfo.Username) {
+				s.notifier.EmitOnce(types.EventQuotaExhausted, quotaEventKey(userInfo, ""), userInfo)
+			}
 		}
 	}
 
-	clientInfo.NetworkType = input.NetworkType
-	s.redisClient.InsertKeyInfo("client", clientInfo.Id, clientInfo, 2*time.Hour)
-
-	return types.ClientLoginOutput{
-		ClientUuid: clientInfo.Id,
-	}, "", 0
-}
-
-func (s *AuthServer) heartbeatRequest(w http.ResponseWriter, req *http.Request) ([]byte, interface{}, string, int) {
-	b, _ := ioutil.ReadAll(req.Body)
+	if clientInfo.ClientUser != input.ClientUser {
+		return nil, "registered user and client report user is not equal", -7
+	}
 
-	var input = types.HeartbeatInput{}
-	err := json.Unmarshal(b, &input)
+	// the hardware is checked before touching the client, so that a rejected
+	// login cannot move it to another network or change its version
+	err = s.checkFingerprint(clientInfo.Id, sessionInfo.Fingerprint)
 	if err != nil {
-		log.Errorf(log.Fields{}, "fail to parse input parameter: %v [%v]", err, string(b))
-		return nil, nil, err.Error(), -1
+		log.Errorf(log.Fields{}, "fail to check fingerprint of %v: %v", clientInfo.Id, err)
+		return nil, err.Error(), -10
 	}
 
-	sessionInfo, err := s.redisClient.QuerySession(input.SessionId)
-	if err != nil {
-		log.Errorf(log.Fields{}, "fail to query session: %v", err)
-		return nil, nil, err.Error(), -3
+	if input.NetworkType != "" && clientInfo.NetworkType != input.NetworkType {
+		err = s.mysqlClient.UpdateClientNetwork(*clientInfo, input.NetworkType)
+		if err == fbcmysql.ErrNetworkQuotaExceeded {
+			log.Errorf(log.Fields{}, "fail to move client %v to %v: %v", clientInfo.Id, input.NetworkType, err)
+			s.notifier.EmitOnce(types.EventQuotaExhausted, quotaEventKey(userInfo, input.NetworkType), userInfo)
+			return nil, err.Error(), -11
+		}
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to update network of client %v: %v", clientInfo.Id, err)
+			return nil, err.Error(), -12
+		}
+		log.Infof(log.Fields{}, "client %v change network from %v to %v", clientInfo.Id, clientInfo.NetworkType, input.NetworkType)
+		clientInfo.NetworkType = input.NetworkType
 	}
 
-	clientInfo, err := s.mysqlClient.QueryClientInfoByClientId(input.ClientUuid)
-	if err != nil {
-		log.Errorf(log.Fields{}, "fail to find client info: %v", err)
-		return nil, nil, err.Error(), -4
+	if input.ClientVersion != "" && clientInfo.ClientVersion != input.ClientVersion {
+		err = s.mysqlClient.UpdateClientVersion(clientInfo.Id, input.ClientVersion)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to update version of client %v: %v", clientInfo.Id, err)
+		}
+		clientInfo.ClientVersion = input.ClientVersion
 	}
 
-	cacheInfo, err := s.redisClient.QueryClient(input.ClientUuid)
-	if err != nil {
+	if userInfo.LicenseMode == fbcmysql.LicenseModeFloating {
+		_, err = s.acquireLease(userInfo, clientInfo.Id)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to acquire lease for %v: %v", clientInfo.Id, err)
+			return nil, err.Error(), -9
+		}
+	}
+
+	s.redisClient.InsertKeyInfo("client", clientInfo.Id, clientInfo, 2*time.Hour)
+	err = s.redisClient.InsertClientSession(clientInfo.Id, input.SessionId)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to insert client session of %v: %v", clientInfo.Id, err)
+	}
+
+	return types.ClientLoginOutput{
+		ClientUuid: clientInfo.Id,
+	}, "", 0
+}
+
+func (s *AuthServer) fingerprintThreshold() int {
+	if s.config.FingerprintCfg.Threshold <= 0 {
+		return defaultFingerprintThreshold
+	}
+	return s.config.FingerprintCfg.Threshold
+}
+
+// matchFingerprintClient finds the offline client of the user whose known hardware
+// is close enough to the reported fingerprint. A client still heartbeating is a
+// different miner on the same or identical hardware, it keeps its own sn.
+func (s *AuthServer) matchFingerprintClient(username string, reported types.HardwareFingerprint) (*types.ClientInfo, error) {
+	lastSeen := time.Now().Add(-s.offlineDuration())
+
+	for _, client := range s.mysqlClient.QueryClientInfosByUser(username) {
+		if lastSeen.Before(client.LastSeen) {
+			continue
+		}
+		known, err := s.mysqlClient.QueryFingerprint(client.Id)
+		if err != nil {
+			continue
+		}
+		if s.fingerprintThreshold() <= fingerprint.Score(*known, reported) {
+			return &client, nil
+		}
+	}
+	return nil, xerrors.Errorf("cannot find client")
+}
+
+// checkFingerprint rejects a client whose sn is reported from different hardware,
+// or without fingerprint once one is known. The first reported fingerprint is kept
+// as reference, so that accepted minor changes cannot drift it to another machine.
+func (s *AuthServer) checkFingerprint(clientId uuid.UUID, reported *types.HardwareFingerprint) error {
+	known, err := s.mysqlClient.QueryFingerprint(clientId)
+	if err != nil {
+		if reported == nil {
+			return nil
+		}
+		return s.mysqlClient.UpdateFingerprint(clientId, *reported)
+	}
+
+	if reported == nil {
+		return xerrors.Errorf("hardware fingerprint is must")
+	}
+
+	score := fingerprint.Score(*known, *reported)
+	if score < s.fingerprintThreshold() {
+		return xerrors.Errorf("hardware fingerprint mismatch: %v of %v components match",
+			score, fingerprint.Components)
+	}
+
+	return nil
+}
+
+func (s *AuthServer) createTrialUser(authCode string, username string) (*types.UserInfo, error) {
+	user, err := authapi.UserInfo(authtypes.UserInfoInput{
+		AuthCode: authCode,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.mysqlClient.CreateTrialUserInfo(types.UserInfo{
+		Id:           user.Id,
+		Username:     username,
+		Quota:        s.config.TrialCfg.Quota,
+		ValidateDate: time.Now().AddDate(0, 0, s.config.TrialCfg.Days),
+		CreateTime:   time.Now(),
+		ModifyTime:   time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof(log.Fields{}, "create trial license for %v", username)
+
+	return s.mysqlClient.QueryUserInfoByUsername(username)
+}
+
+func (s *AuthServer) heartbeatRequest(w http.ResponseWriter, req *http.Request) ([]byte, interface{}, string, int) {
+	b, _ := ioutil.ReadAll(req.Body)
+
+	var input = types.HeartbeatInput{}
+	err := json.Unmarshal(b, &input)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to parse input parameter: %v [%v]", err, string(b))
+		return nil, nil, err.Error(), -1
+	}
+
+	sessionInfo, err := s.redisClient.QuerySession(input.SessionId)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to query session: %v", err)
+		return nil, nil, err.Error(), -3
+	}
+
+	clientInfo, err := s.mysqlClient.QueryClientInfoByClientId(input.ClientUuid)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to find client info: %v", err)
+		return nil, nil, err.Error(), -4
+	}
+
+	_, err = s.redisClient.QueryClient(input.ClientUuid)
+	if err != nil {
 		log.Errorf(log.Fields{}, "fail to query client info: %v", err)
 		return nil, nil, err.Error(), -5
 	}
 
-	clientInfo.NetworkType = cacheInfo.NetworkType
 	s.redisClient.InsertKeyInfo("client", clientInfo.Id, clientInfo, 2*time.Hour)
 
-	shouldStop := false
+	now := time.Now()
+	err = s.mysqlClient.UpdateClientLastSeen(clientInfo.Id, now)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to update last seen of %v: %v", clientInfo.Id, err)
+	}
+
+	err = s.mysqlClient.RecordOnlineInterval(clientInfo.Id, now, s.offlineDuration())
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to record online interval of %v: %v", clientInfo.Id, err)
+	}
+
+	if input.ClientVersion != "" && clientInfo.ClientVersion != input.ClientVersion {
+		err = s.mysqlClient.UpdateClientVersion(clientInfo.Id, input.ClientVersion)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to update version of client %v: %v", clientInfo.Id, err)
+		}
+		clientInfo.ClientVersion = input.ClientVersion
+	}
+
+	if len(input.Counters) > 0 {
+		err = s.mysqlClient.RecordUsage(*clientInfo, input.CountersEpoch, input.Counters, now)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to record usage of %v: %v", clientInfo.Id, err)
+		}
+	}
+
+	userInfo, err := s.mysqlClient.QueryUserInfoByUsername(clientInfo.ClientUser)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to find client user: %v", err)
+		return nil, nil, err.Error(), -6
+	}
+
+	output := s.checkLicenseExpire(userInfo)
+	if output.WarningLevel == types.WarningGrace || output.WarningLevel == types.WarningExpired {
+		s.notifier.EmitOnce(types.EventLicenseExpired, expiredEventKey(userInfo), userInfo)
+	}
+
 	switch clientInfo.Status {
 	case fbcmysql.StatusDisable:
-		shouldStop = true
+		output.ShouldStop = true
+		output.StopCode = types.StopDisabled
+	default:
+		if s.checkClone(clientInfo, input.SessionId, s.remoteIp(req)) {
+			output.ShouldStop = true
+			output.StopCode = types.StopClone
+		}
 	}
 
-	output := types.HeartbeatOutput{
-		ShouldStop: shouldStop,
+	s.checkVersion(clientInfo, &output)
+
+	for _, ack := range input.CommandAcks {
+		err = s.mysqlClient.AckCommand(clientInfo.Id, ack)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to ack command %v of %v: %v", ack.Id, clientInfo.Id, err)
+		}
+	}
+
+	if maintenance := s.activeMaintenance(clientInfo, now); maintenance != nil {
+		output.Maintenance = &types.MaintenanceWindow{
+			Id:        maintenance.Id,
+			StartTime: maintenance.StartTime,
+			EndTime:   maintenance.EndTime,
+			Reason:    maintenance.Reason,
+		}
+	}
+
+	// a command not acknowledged within the offline duration was lost with
+	// the heartbeat response, deliver it again
+	commands, err := s.mysqlClient.DeliverCommands(*clientInfo, now, s.offlineDuration())
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to deliver commands to %v: %v", clientInfo.Id, err)
+	}
+	for _, command := range commands {
+		output.Commands = append(output.Commands, types.RemoteCommand{
+			Id:         command.Id,
+			Command:    command.Command,
+			Payload:    command.Payload,
+			ExpireTime: command.ExpireTime,
+		})
+	}
+
+	output.Entitlements = s.mysqlClient.QueryClientEntitlements(clientInfo.ClientUser, clientInfo.Id)
+
+	if userInfo.LicenseMode == fbcmysql.LicenseModeFloating {
+		if output.ShouldStop {
+			// a client told to stop gives its seat back at once
+			err = s.redisClient.ReleaseLease(userInfo.Username, clientInfo.Id)
+			if err != nil {
+				log.Errorf(log.Fields{}, "fail to release lease of %v: %v", clientInfo.Id, err)
+			}
+		} else {
+			output.LeaseUntil, err = s.acquireLease(userInfo, clientInfo.Id)
+			if err != nil {
+				log.Errorf(log.Fields{}, "fail to renew lease for %v: %v", clientInfo.Id, err)
+				output.ShouldStop = true
+				output.StopCode = types.StopLease
+			}
+		}
 	}
 
 	return []byte(sessionInfo.MyPubKey), output, "", 0
 }
 
+func (s *AuthServer) checkLicenseExpire(userInfo *types.UserInfo) types.HeartbeatOutput {
+	output := types.HeartbeatOutput{
+		WarningLevel: types.WarningNone,
+	}
+
+	if userInfo.ValidateDate.IsZero() {
+		return output
+	}
+
+	now := time.Now()
+	output.ExpireAt = userInfo.ValidateDate
+	output.GraceUntil = userInfo.ValidateDate.AddDate(0, 0, s.config.LicenseCfg.GraceDays)
+
+	switch {
+	case now.After(output.GraceUntil):
+		output.ShouldStop = true
+		output.StopCode = types.StopExpired
+		output.WarningLevel = types.WarningExpired
+	case now.After(output.ExpireAt):
+		output.WarningLevel = types.WarningGrace
+	case now.AddDate(0, 0, s.config.LicenseCfg.WarnDays).After(output.ExpireAt):
+		output.WarningLevel = types.WarningExpiring
+	}
+
+	if output.ShouldStop {
+		log.Infof(log.Fields{}, "license of %v expired at %v", userInfo.Username, output.ExpireAt)
+	}
+
+	return output
+}
+
+// checkVersion applies the version policy of the client. Blocked versions and
+// versions older than the minimum are stopped. Clients reporting no version were
+// built before versions were reported, they are not stopped by the minimum but
+// are still offered the recommended version as upgrade target.
+func (s *AuthServer) checkVersion(clientInfo *types.ClientInfo, output *types.HeartbeatOutput) {
+	policy, err := s.mysqlClient.QueryVersionPolicy(clientInfo.ClientUser, clientInfo.NetworkType)
+	if err != nil {
+		return
+	}
+
+	clientVersion := clientInfo.ClientVersion
+	stopReason := ""
+
+	for _, blocked := range policy.Blocked {
+		if clientVersion != "" && version.Equal(clientVersion, blocked) {
+			stopReason = fmt.Sprintf("client version %v is blocked", clientVersion)
+			break
+		}
+	}
+	if stopReason == "" && clientVersion != "" && policy.MinVersion != "" && version.Compare(clientVersion, policy.MinVersion) < 0 {
+		stopReason = fmt.Sprintf("client version %v is older than minimum version %v", clientVersion, policy.MinVersion)
+	}
+
+	upgradeTo := policy.Recommended
+	if upgradeTo == "" {
+		upgradeTo = policy.MinVersion
+	}
+
+	if stopReason != "" {
+		log.Infof(log.Fields{}, "stop client %v: %v", clientInfo.Id, stopReason)
+		output.ShouldStop = true
+		output.StopCode = types.StopVersion
+		output.StopReason = stopReason
+		if !version.Equal(clientVersion, upgradeTo) {
+			output.UpgradeTo = upgradeTo
+		}
+		return
+	}
+
+	if policy.Recommended != "" && version.Compare(clientVersion, policy.Recommended) < 0 {
+		output.UpgradeTo = policy.Recommended
+	}
+}
+
+func (s *AuthServer) acquireLease(userInfo *types.UserInfo, cid uuid.UUID) (time.Time, error) {
+	minutes := s.config.LicenseCfg.LeaseMinutes
+	if minutes <= 0 {
+		minutes = defaultLeaseMinutes
+	}
+	ttl := time.Duration(minutes) * time.Minute
+
+	ok, err := s.redisClient.AcquireLease(userInfo.Username, cid, userInfo.Quota, ttl)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !ok {
+		return time.Time{}, xerrors.Errorf("no floating seat available for %v", userInfo.Username)
+	}
+
+	return time.Now().Add(ttl), nil
+}
+
+// concurrentSources reports which heartbeat sources, each being session@ip, were
+// alive at the same time. A client whose ip changes or which restarts stops the
+// old source before the new one starts, so neither is concurrent. Concurrent
+// sources of different sessions are clones, while concurrent ips of one session
+// may be a NAT with several egress addresses. The session seen first among the
+// concurrent sessions is returned, a session stopped before the others is not one.
+func concurrentSources(sources []fbcredis.HeartbeatSource) (string, bool, bool) {
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].First.Before(sources[j].First)
+	})
+
+	session := func(source string) string {
+		return strings.SplitN(source, "@", 2)[0]
+	}
+
+	first := -1
+	ips := false
+	for i, source := range sources {
+		for j, earlier := range sources[:i] {
+			if !earlier.Last.After(source.First) {
+				continue
+			}
+			if session(earlier.Source) != session(source.Source) {
+				if first < 0 || j < first {
+					first = j
+				}
+			} else {
+				ips = true
+			}
+		}
+	}
+
+	if first < 0 {
+		return "", false, ips
+	}
+	return session(sources[first].Source), true, ips
+}
+
+// checkClone tracks the heartbeat sources of the client and applies the clone
+// policy when two sessions heartbeat at the same time, it returns true if this
+// session should stop. The ip must come from remoteIp so it cannot be forged.
+func (s *AuthServer) checkClone(clientInfo *types.ClientInfo, sessionId uuid.UUID, ip string) bool {
+	windowSeconds := s.config.CloneCfg.WindowSeconds
+	if windowSeconds <= 0 {
+		windowSeconds = defaultCloneWindowSeconds
+	}
+
+	heartbeatSources, err := s.redisClient.RecordHeartbeatSource(clientInfo.Id,
+		fmt.Sprintf("%v@%v", sessionId, ip), time.Duration(windowSeconds)*time.Second)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to record heartbeat source of %v: %v", clientInfo.Id, err)
+		return false
+	}
+
+	firstSession, sessions, ips := concurrentSources(heartbeatSources)
+	if !sessions && !ips {
+		return false
+	}
+
+	sources := []string{}
+	for _, source := range heartbeatSources {
+		sources = append(sources, source.Source)
+	}
+
+	log.Errorf(log.Fields{}, "client %v is suspected clone: %v", clientInfo.Id, sources)
+	s.notifier.EmitOnce(types.EventCloneSuspected, fmt.Sprintf("%v:%v", clientInfo.Id, strings.Join(sources, ",")), map[string]interface{}{
+		"client":  clientInfo,
+		"sources": sources,
+	})
+
+	// only the ip differs, alert but never flag, stop or disable
+	if !sessions {
+		return false
+	}
+
+	s.redisClient.InsertKeyInfo("clone", clientInfo.Id, fbcredis.CloneInfo{
+		Sources: sources,
+	}, cloneFlagDuration)
+
+	switch s.config.CloneCfg.Policy {
+	case ClonePolicyStop:
+		return firstSession != sessionId.String()
+	case ClonePolicyDisable:
+		err = s.mysqlClient.UpdateClientStatus([]uuid.UUID{clientInfo.Id}, fbcmysql.StatusDisable,
+			fmt.Sprintf("suspected clone from %v", sources), "system")
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to disable client %v: %v", clientInfo.Id, err)
+		} else {
+			s.notifier.EmitOnce(types.EventClientDisabled, disabledEventKey(clientInfo), clientInfo)
+		}
+		return true
+	}
+
+	return false
+}
+
+func expiredEventKey(userInfo *types.UserInfo) string {
+	return fmt.Sprintf("%v:%v", userInfo.Username, userInfo.ValidateDate.Unix())
+}
+
+// quotaEventKey changes with the quota, so the exhaustion is emitted again once
+// the license is updated and used up again
+func quotaEventKey(userInfo *types.UserInfo, networkType string) string {
+	return fmt.Sprintf("%v:%v:%v:%v", userInfo.Username, networkType, userInfo.Quota, userInfo.ModifyTime.Unix())
+}
+
+// disabledEventKey identifies the disabling by the client row before it, every
+// enable changes the row so the next disabling is emitted again
+func disabledEventKey(clientInfo *types.ClientInfo) string {
+	return fmt.Sprintf("%v:%v", clientInfo.Id, clientInfo.ModifyTime.UnixNano())
+}
+
 func (s *AuthServer) HeartbeatRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
 	pubKey, output, msg, code := s.heartbeatRequest(w, req)
 	if code != 0 {
 		return nil, msg, code
 	}
 
-	b, _ := json.Marshal(output)
+	// v0 clients decrypt a single block, the extended output is only on v1
+	heartbeat := output.(types.HeartbeatOutput)
+	b, _ := json.Marshal(types.HeartbeatV0Output{
+		ShouldStop: heartbeat.ShouldStop,
+		StopReason: heartbeat.StopCode,
+	})
 	remoteRsa := crypto.NewRsaCryptoWithParam([]byte(pubKey), nil)
-	cipherText, _ := remoteRsa.Encrypt(b)
+	cipherText, err := remoteRsa.Encrypt(b)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to encrypt heartbeat output: %v", err)
+		return nil, err.Error(), -7
+	}
 
 	return hex.EncodeToString(cipherText), "", 0
 }
@@ -380,15 +1240,48 @@ func (s *AuthServer) MyClientsRequest(w http.ResponseWriter, req *http.Request)
 	output := types.MyClientsOutput{
 		SuperUser:   user.SuperUser,
 		VisitorOnly: user.VisitorOnly,
+		Reseller:    clientUser.Reseller,
 	}
 	if user.SuperUser {
 		output.Clients = s.mysqlClient.QueryClientInfos()
 		output.Users = s.mysqlClient.QueryUserInfos()
+	} else if clientUser.Reseller {
+		output.Users = s.mysqlClient.QuerySubUserInfos(*clientUser)
+		for _, subUser := range output.Users {
+			output.Clients = append(output.Clients, s.mysqlClient.QueryClientInfosByUser(subUser.Username)...)
+		}
 	} else {
 		output.Users = []types.UserInfo{*clientUser}
 		output.Clients = s.mysqlClient.QueryClientInfosByUser(clientUser.Username)
 	}
 
+	for i, userInfo := range output.Users {
+		output.Users[i].NetworkQuotas = s.mysqlClient.QueryNetworkQuotas(userInfo.Username)
+	}
+
+	output.Versions = map[string]int{}
+	for _, client := range output.Clients {
+		clientVersion := client.ClientVersion
+		if clientVersion == "" {
+			clientVersion = "unknown"
+		}
+		output.Versions[clientVersion] += 1
+	}
+
+	usernames := []string{}
+	seen := map[string]bool{}
+	for _, client := range output.Clients {
+		if !seen[client.ClientUser] {
+			seen[client.ClientUser] = true
+			usernames = append(usernames, client.ClientUser)
+		}
+	}
+
+	maintenances := map[string][]types.MaintenanceInfo{}
+	for _, info := range s.mysqlClient.QueryUsersActiveMaintenanceInfos(usernames, time.Now()) {
+		maintenances[info.ClientUser] = append(maintenances[info.ClientUser], info)
+	}
+
 	for i, client := range output.Clients {
 		expire, err := s.redisClient.QueryClientExpire(client.Id)
 		if err != nil {
@@ -398,10 +1291,16 @@ func (s *AuthServer) MyClientsRequest(w http.ResponseWriter, req *http.Request)
 				output.Clients[i].Status = fbcmysql.StatusOffline
 			}
 		}
-		info, err := s.redisClient.QueryClient(client.Id)
-		if err == nil {
-			output.Clients[i].NetworkType = info.NetworkType
+		if client.Status != fbcmysql.StatusDisable {
+			for _, info := range maintenances[client.ClientUser] {
+				if maintenanceCovers(info, client) {
+					output.Clients[i].Status = fbcmysql.StatusMaintaining
+					break
+				}
+			}
 		}
+		_, err = s.redisClient.QueryClone(client.Id)
+		output.Clients[i].CloneSuspected = err == nil
 	}
 
 	return output, "", 0
@@ -430,8 +1329,12 @@ func (s *AuthServer) UpdateAuthRequest(w http.ResponseWriter, req *http.Request)
 		return nil, err.Error(), -4
 	}
 
+	var reseller *types.UserInfo
 	if !user.SuperUser {
-		return nil, "operation not allowed", -5
+		reseller, err = s.mysqlClient.QueryUserInfoById(user.Id)
+		if err != nil || !reseller.Reseller {
+			return nil, "operation not allowed", -5
+		}
 	}
 
 	if user.VisitorOnly {
@@ -446,65 +1349,1593 @@ func (s *AuthServer) UpdateAuthRequest(w http.ResponseWriter, req *http.Request)
 		return nil, err.Error(), -7
 	}
 
+	if input.Quota < 0 || input.ValidateDate < 0 {
+		return nil, "invalid quota or validate date", -14
+	}
+
+	if reseller != nil {
+		if usernameInfo.Id == reseller.Id || input.LicenseMode != "" || input.Reseller != nil || input.Parent != nil {
+			return nil, "operation not allowed", -12
+		}
+	}
+
+	var before interface{}
+
+	// a reseller only manages the users a super user assigned to its subtree
 	clientUser, err := s.mysqlClient.QueryUserInfoById(usernameInfo.Id)
 	if err != nil {
+		if reseller != nil {
+			return nil, "operation not allowed", -12
+		}
 		clientUser = &types.UserInfo{
 			Id:         usernameInfo.Id,
 			Username:   input.Username,
 			CreateTime: time.Now(),
 		}
+	} else {
+		clientUser.NetworkQuotas = s.mysqlClient.QueryNetworkQuotas(clientUser.Username)
+		before = *clientUser
+		if reseller != nil && !s.subUser(reseller, clientUser.Username) {
+			return nil, "operation not allowed", -12
+		}
 	}
 
 	clientUser.Quota = input.Quota
 	clientUser.ModifyTime = time.Now()
 	clientUser.ValidateDate = time.Now().AddDate(0, 0, input.ValidateDate)
+	clientUser.PlanId = uuid.Nil
+	clientUser.PlanVersion = 0
+	clientUser.Trial = false
+
+	switch input.LicenseMode {
+	case "":
+	case fbcmysql.LicenseModeNodeLocked, fbcmysql.LicenseModeFloating:
+		clientUser.LicenseMode = input.LicenseMode
+	default:
+		return nil, "invalid license mode", -11
+	}
 
-	err = s.mysqlClient.UpdateAuth(*clientUser)
-	if err != nil {
-		return nil, err.Error(), -8
+	var plan *types.PlanInfo
+	if input.Plan != "" {
+		plan, err = s.mysqlClient.QueryPlanInfoByName(input.Plan)
+		if err != nil {
+			return nil, err.Error(), -9
+		}
+		clientUser.Quota = plan.Quota
+		clientUser.ValidateDate = time.Now().AddDate(0, 0, plan.Duration)
+		clientUser.PlanId = plan.Id
+		clientUser.PlanVersion = plan.Version
 	}
 
-	return nil, "", 0
-}
+	if input.Reseller != nil {
+		clientUser.Reseller = *input.Reseller
+	}
 
-func (s *AuthServer) ClientInfoByIdRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
-	b, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		return nil, err.Error(), -1
+	if input.Parent != nil {
+		clientUser.ParentId = uuid.Nil
+		if *input.Parent != "" {
+			parent, err := s.mysqlClient.QueryUserInfoByUsername(*input.Parent)
+			if err != nil || !parent.Reseller || parent.Id == clientUser.Id || s.subUser(clientUser, parent.Username) {
+				return nil, "invalid parent", -15
+			}
+			clientUser.ParentId = parent.Id
+		}
 	}
 
-	input := types.ClientInfoByIdInput{}
-	err = json.Unmarshal(b, &input)
+	if input.NetworkQuotas != nil {
+		for networkType, quota := range input.NetworkQuotas {
+			if networkType == "" || quota < 0 {
+				return nil, "invalid network quota", -13
+			}
+		}
+		clientUser.NetworkQuotas = input.NetworkQuotas
+	}
+
+	if reseller != nil {
+		if clientUser.ValidateDate.After(reseller.ValidateDate) {
+			clientUser.ValidateDate = reseller.ValidateDate
+		}
+		err = s.mysqlClient.AllocateQuota(reseller.Id, *clientUser)
+	} else {
+		err = s.mysqlClient.UpdateAuth(*clientUser)
+	}
 	if err != nil {
-		return nil, err.Error(), -2
+		return nil, err.Error(), -8
 	}
 
-	clientInfo, err := s.mysqlClient.QueryClientInfoByClientId(input.Id)
+	if input.NetworkQuotas != nil {
+		err = s.mysqlClient.UpdateNetworkQuotas(clientUser.Username, input.NetworkQuotas)
+		if err != nil {
+			return nil, err.Error(), -8
+		}
+	}
+
+	err = s.applyPlanEntitlements(clientUser.Username, plan)
 	if err != nil {
-		log.Errorf(log.Fields{}, "fail to find client info: %v", err)
-		return nil, err.Error(), -3
+		return nil, err.Error(), -10
 	}
 
-	return clientInfo, "", 0
+	s.audit(req, user, types.AuditUpdateAuth, clientUser.Username, before, clientUser)
+
+	if !clientUser.ValidateDate.After(time.Now()) {
+		s.notifier.EmitOnce(types.EventLicenseExpired, expiredEventKey(clientUser), clientUser)
+	}
+	if clientUser.LicenseMode != fbcmysql.LicenseModeFloating &&
+		clientUser.Quota <= s.mysqlClient.QueryClientCount(clientUser.Username) {
+		s.notifier.EmitOnce(types.EventQuotaExhausted, quotaEventKey(clientUser, ""), clientUser)
+	}
+
+	return nil, "", 0
 }
 
-func (s *AuthServer) ClientInfoBySpecRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+func (s *AuthServer) UpdateEntitlementRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		return nil, err.Error(), -1
 	}
 
-	input := types.ClientInfoBySpecInput{}
+	input := types.UpdateEntitlementInput{}
 	err = json.Unmarshal(b, &input)
 	if err != nil {
 		return nil, err.Error(), -2
 	}
 
-	clientInfo, err := s.mysqlClient.QueryClientInfoByClientSn(input.Spec)
+	user, err := s.checkSuperUser(input.AuthCode)
 	if err != nil {
-		log.Errorf(log.Fields{}, "fail to find client info: %v", err)
 		return nil, err.Error(), -3
 	}
 
-	return clientInfo, "", 0
+	_, err = s.mysqlClient.QueryUserInfoByUsername(input.Username)
+	if err != nil {
+		return nil, err.Error(), -4
+	}
+
+	if input.ClientId != uuid.Nil {
+		clientInfo, err := s.mysqlClient.QueryClientInfoByClientId(input.ClientId)
+		if err != nil {
+			return nil, err.Error(), -5
+		}
+		if clientInfo.ClientUser != input.Username {
+			return nil, "client is not belong to user", -6
+		}
+	}
+
+	before := s.mysqlClient.QueryClientEntitlements(input.Username, input.ClientId)
+
+	for name, value := range input.Entitlements {
+		if name == "" {
+			return nil, "entitlement name is must", -7
+		}
+		err = s.mysqlClient.UpdateEntitlement(types.EntitlementInfo{
+			Username: input.Username,
+			ClientId: input.ClientId,
+			Name:     name,
+			Value:    value,
+		})
+		if err != nil {
+			return nil, err.Error(), -8
+		}
+	}
+
+	log.Infof(log.Fields{}, "%v update entitlements of %v [%v]: %v",
+		user.Username, input.Username, input.ClientId, input.Entitlements)
+
+	target := input.Username
+	if input.ClientId != uuid.Nil {
+		target = input.ClientId.String()
+	}
+	s.audit(req, user, types.AuditUpdateEntitlement, target, before,
+		s.mysqlClient.QueryClientEntitlements(input.Username, input.ClientId))
+
+	return nil, "", 0
+}
+
+func (s *AuthServer) EntitlementsRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.EntitlementsInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	_, err = s.checkSuperUser(input.AuthCode)
+	if err != nil {
+		return nil, err.Error(), -3
+	}
+
+	return types.EntitlementsOutput{
+		Entitlements: s.mysqlClient.QueryEntitlements(input.Username),
+	}, "", 0
+}
+
+// applyPlanEntitlements replaces the entitlements the user got from its previous
+// plan with those of plan, a nil plan only removes them
+func (s *AuthServer) applyPlanEntitlements(username string, plan *types.PlanInfo) error {
+	return s.mysqlClient.ReplacePlanEntitlements(username, plan)
+}
+
+func (s *AuthServer) CreatePlanRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.CreatePlanInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	user, err := s.checkSuperUser(input.AuthCode)
+	if err != nil {
+		return nil, err.Error(), -3
+	}
+
+	if input.Name == "" {
+		return nil, "plan name is must", -4
+	}
+
+	if input.Quota < 0 || input.Duration < 0 {
+		return nil, "invalid quota or duration", -7
+	}
+
+	_, err = s.mysqlClient.QueryPlanInfoByName(input.Name)
+	if err == nil {
+		return nil, "plan already exists", -5
+	}
+
+	plan := types.PlanInfo{
+		Id:           uuid.New(),
+		Name:         input.Name,
+		Quota:        input.Quota,
+		Duration:     input.Duration,
+		Version:      1,
+		Entitlements: input.Entitlements,
+		CreateTime:   time.Now(),
+		ModifyTime:   time.Now(),
+	}
+
+	err = s.mysqlClient.InsertPlanInfo(plan)
+	if err != nil {
+		return nil, err.Error(), -6
+	}
+
+	log.Infof(log.Fields{}, "%v create plan %v", user.Username, plan.Name)
+	s.audit(req, user, types.AuditCreatePlan, plan.Name, nil, plan)
+
+	return plan, "", 0
+}
+
+// UpdatePlanRequest bumps the plan version. With apply, quota and entitlements
+// are pushed to the users of the plan; their validate date is kept. Every user is
+// applied on its own, the users failed are reported.
+func (s *AuthServer) UpdatePlanRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.UpdatePlanInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	user, err := s.checkSuperUser(input.AuthCode)
+	if err != nil {
+		return nil, err.Error(), -3
+	}
+
+	plan, err := s.mysqlClient.QueryPlanInfoById(input.Id)
+	if err != nil {
+		return nil, err.Error(), -4
+	}
+
+	if (input.Quota != nil && *input.Quota < 0) || (input.Duration != nil && *input.Duration < 0) {
+		return nil, "invalid quota or duration", -7
+	}
+
+	before := *plan
+
+	if input.Name != "" && input.Name != plan.Name {
+		_, err = s.mysqlClient.QueryPlanInfoByName(input.Name)
+		if err == nil {
+			return nil, "plan already exists", -5
+		}
+		plan.Name = input.Name
+	}
+
+	if input.Quota != nil {
+		plan.Quota = *input.Quota
+	}
+	if input.Duration != nil {
+		plan.Duration = *input.Duration
+	}
+	if input.Entitlements != nil {
+		plan.Entitlements = input.Entitlements
+	}
+	plan.Version += 1
+	plan.ModifyTime = time.Now()
+
+	err = s.mysqlClient.UpdatePlanInfo(*plan)
+	if err != nil {
+		return nil, err.Error(), -6
+	}
+
+	log.Infof(log.Fields{}, "%v update plan %v to version %v", user.Username, plan.Name, plan.Version)
+	s.audit(req, user, types.AuditUpdatePlan, plan.Name, before, plan)
+
+	output := types.UpdatePlanOutput{
+		PlanInfo: *plan,
+	}
+	if !input.Apply {
+		return output, "", 0
+	}
+
+	for _, clientUser := range s.mysqlClient.QueryUserInfosByPlan(plan.Id) {
+		before := clientUser
+		clientUser.Quota = plan.Quota
+		clientUser.PlanVersion = plan.Version
+		clientUser.ModifyTime = time.Now()
+
+		err = s.mysqlClient.ApplyPlan(clientUser, *plan)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to apply plan %v to %v: %v", plan.Name, clientUser.Username, err)
+			output.FailedUsers = append(output.FailedUsers, clientUser.Username)
+			continue
+		}
+
+		s.audit(req, user, types.AuditUpdateAuth, clientUser.Username, before, clientUser)
+	}
+
+	return output, "", 0
+}
+
+func (s *AuthServer) PlansRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.PlansInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	_, err = s.checkSuperUser(input.AuthCode)
+	if err != nil {
+		return nil, err.Error(), -3
+	}
+
+	output := types.PlansOutput{}
+	for _, plan := range s.mysqlClient.QueryPlanInfos() {
+		detail := types.PlanDetail{
+			PlanInfo: plan,
+		}
+		for _, clientUser := range s.mysqlClient.QueryUserInfosByPlan(plan.Id) {
+			detail.Users = append(detail.Users, clientUser.Username)
+			if clientUser.PlanVersion < plan.Version {
+				detail.OutdatedUsers = append(detail.OutdatedUsers, clientUser.Username)
+			}
+		}
+		output.Plans = append(output.Plans, detail)
+	}
+
+	return output, "", 0
+}
+
+func (s *AuthServer) DeregisterClientRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.DeregisterClientInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	if input.AuthCode == "" {
+		return nil, "auth code is must", -3
+	}
+
+	user, err := authapi.UserInfo(authtypes.UserInfoInput{
+		AuthCode: input.AuthCode,
+	})
+	if err != nil {
+		return nil, err.Error(), -4
+	}
+
+	if user.VisitorOnly {
+		return nil, "operation not allowed", -5
+	}
+
+	clientInfo, err := s.mysqlClient.QueryClientInfoByClientId(input.ClientId)
+	if err != nil {
+		return nil, err.Error(), -6
+	}
+
+	if !user.SuperUser && clientInfo.ClientUser != user.Username {
+		return nil, "operation not allowed", -7
+	}
+
+	err = s.mysqlClient.DeregisterClientInfo(*clientInfo, user.Username)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to deregister client %v: %v", clientInfo.Id, err)
+		return nil, err.Error(), -8
+	}
+
+	err = s.redisClient.PurgeClient(clientInfo.Id)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to purge client %v: %v", clientInfo.Id, err)
+	}
+
+	err = s.redisClient.ReleaseLease(clientInfo.ClientUser, clientInfo.Id)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to release lease of %v: %v", clientInfo.Id, err)
+	}
+
+	log.Infof(log.Fields{}, "%v deregister client %v [%v] of %v",
+		user.Username, clientInfo.Id, clientInfo.ClientSn, clientInfo.ClientUser)
+	s.audit(req, user, types.AuditDeregisterClient, clientInfo.Id.String(), clientInfo, nil)
+
+	return nil, "", 0
+}
+
+func (s *AuthServer) UpdateStatusRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.UpdateStatusInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	user, err := s.checkSuperUser(input.AuthCode)
+	if err != nil {
+		return nil, err.Error(), -3
+	}
+
+	if len(input.ClientIds) == 0 {
+		return nil, "client id is must", -4
+	}
+
+	if input.Reason == "" {
+		return nil, "reason is must", -5
+	}
+
+	_, err = s.mysqlClient.QueryStatusInfo(input.Status)
+	if err != nil {
+		return nil, "invalid status", -6
+	}
+
+	befores := map[uuid.UUID]*types.ClientInfo{}
+	for _, id := range input.ClientIds {
+		clientInfo, err := s.mysqlClient.QueryClientInfoByClientId(id)
+		if err == nil {
+			befores[id] = clientInfo
+		}
+	}
+
+	err = s.mysqlClient.UpdateClientStatus(input.ClientIds, input.Status, input.Reason, user.Username)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to update client status: %v", err)
+		return nil, err.Error(), -7
+	}
+
+	log.Infof(log.Fields{}, "%v update status of %v to %v: %v",
+		user.Username, input.ClientIds, input.Status, input.Reason)
+
+	for _, id := range input.ClientIds {
+		before := befores[id]
+		status := ""
+		if before != nil {
+			status = before.Status
+		}
+		s.audit(req, user, types.AuditUpdateStatus, id.String(),
+			map[string]string{"status": status},
+			map[string]string{"status": input.Status, "reason": input.Reason})
+		if before != nil && input.Status == fbcmysql.StatusDisable && status != fbcmysql.StatusDisable {
+			clientInfo, err := s.mysqlClient.QueryClientInfoByClientId(id)
+			if err == nil {
+				s.notifier.EmitOnce(types.EventClientDisabled, disabledEventKey(before), clientInfo)
+			}
+		}
+	}
+
+	return nil, "", 0
+}
+
+func (s *AuthServer) clientUptime(client types.ClientInfo, start time.Time, end time.Time) types.ClientUptime {
+	uptime := types.ClientUptime{
+		ClientId: client.Id,
+		ClientSn: client.ClientSn,
+		LastSeen: client.LastSeen,
+		Outages:  []types.OutageInfo{},
+	}
+
+	if start.Before(client.CreateTime) {
+		start = client.CreateTime
+	}
+	if !end.After(start) {
+		return uptime
+	}
+
+	online := time.Duration(0)
+	cursor := start
+
+	for _, interval := range s.mysqlClient.QueryOnlineIntervals(client.Id, start, end) {
+		intervalStart := interval.StartTime
+		if intervalStart.Before(cursor) {
+			intervalStart = cursor
+		}
+		intervalEnd := interval.EndTime
+		if intervalEnd.After(end) {
+			intervalEnd = end
+		}
+		if intervalStart.After(cursor) {
+			uptime.Outages = append(uptime.Outages, types.OutageInfo{
+				StartTime: cursor,
+				EndTime:   intervalStart,
+			})
+		}
+		if intervalEnd.After(intervalStart) {
+			online += intervalEnd.Sub(intervalStart)
+		}
+		if intervalEnd.After(cursor) {
+			cursor = intervalEnd
+		}
+	}
+
+	if end.After(cursor) {
+		uptime.Outages = append(uptime.Outages, types.OutageInfo{
+			StartTime: cursor,
+			EndTime:   end,
+		})
+	}
+
+	uptime.Uptime = float64(online) * 100 / float64(end.Sub(start))
+
+	return uptime
+}
+
+func (s *AuthServer) UptimeRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.UptimeInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	if input.AuthCode == "" {
+		return nil, "auth code is must", -3
+	}
+
+	user, err := authapi.UserInfo(authtypes.UserInfoInput{
+		AuthCode: input.AuthCode,
+	})
+	if err != nil {
+		return nil, err.Error(), -4
+	}
+
+	userId := user.Id
+	if user.VisitorOnly {
+		owner, err := authapi.VisitorOwner(authtypes.VisitorOwnerInput{
+			AuthCode: input.AuthCode,
+		})
+		if err != nil {
+			return nil, err.Error(), -5
+		}
+		userId = owner.Owner
+	}
+
+	clientUser, err := s.mysqlClient.QueryUserInfoById(userId)
+	if err != nil && !user.SuperUser {
+		return nil, err.Error(), -6
+	}
+
+	var clients []types.ClientInfo
+
+	if input.ClientId != uuid.Nil {
+		clientInfo, err := s.mysqlClient.QueryClientInfoByClientId(input.ClientId)
+		if err != nil {
+			return nil, err.Error(), -7
+		}
+		clients = []types.ClientInfo{*clientInfo}
+	} else {
+		username := input.Username
+		if username == "" && clientUser != nil {
+			username = clientUser.Username
+		}
+		clients = s.mysqlClient.QueryClientInfosByUser(username)
+	}
+
+	if !user.SuperUser {
+		for _, client := range clients {
+			if client.ClientUser != clientUser.Username {
+				return nil, "operation not allowed", -8
+			}
+		}
+	}
+
+	output := types.UptimeOutput{
+		StartTime: input.StartTime,
+		EndTime:   input.EndTime,
+		Clients:   []types.ClientUptime{},
+	}
+	if output.EndTime.IsZero() || output.EndTime.After(time.Now()) {
+		output.EndTime = time.Now()
+	}
+	if output.StartTime.IsZero() {
+		output.StartTime = output.EndTime.AddDate(0, 0, -7)
+	}
+
+	for _, client := range clients {
+		output.Clients = append(output.Clients, s.clientUptime(client, output.StartTime, output.EndTime))
+	}
+
+	return output, "", 0
+}
+
+func (s *AuthServer) RevocationsRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.RevocationsInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	list := types.RevocationList{
+		Type:    types.DocumentRevocationList,
+		Version: s.mysqlClient.QueryRevocationVersion(),
+		Since:   input.Since,
+	}
+	if list.Version < input.Since {
+		list.Since = 0
+	}
+
+	list.Entries = s.mysqlClient.QueryRevocations(list.Since)
+	for i, entry := range list.Entries {
+		if list.Version < entry.Version {
+			list.Version = entry.Version
+		}
+		// the list is public for the miners, so sn of customers are only
+		// published as digests which the checker compares with its own sn
+		if entry.ClientSn != "" {
+			list.Entries[i].ClientSn = crypto.Digest(entry.ClientSn)
+		}
+	}
+
+	payload, _ := json.Marshal(list)
+	signature, err := s.licenseKey.Sign(payload)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to sign revocations: %v", err)
+		return nil, err.Error(), -3
+	}
+
+	return types.RevocationsOutput{
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+		Signature: base64.StdEncoding.EncodeToString(signature),
+	}, "", 0
+}
+
+const maxActivationCodes = 1000
+
+func newActivationCode() (string, error) {
+	b := make([]byte, 10)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	code := base32.StdEncoding.EncodeToString(b)
+	return fmt.Sprintf("%v-%v-%v-%v", code[0:4], code[4:8], code[8:12], code[12:16]), nil
+}
+
+func (s *AuthServer) CreateActivationCodesRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.CreateActivationCodesInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	user, err := s.checkSuperUser(input.AuthCode)
+	if err != nil {
+		return nil, err.Error(), -3
+	}
+
+	if input.Count <= 0 || maxActivationCodes < input.Count {
+		return nil, fmt.Sprintf("count should be 1 - %v", maxActivationCodes), -4
+	}
+
+	if input.ExpireDays <= 0 {
+		return nil, "expire days is must", -5
+	}
+
+	planId := uuid.Nil
+	if input.Plan != "" {
+		plan, err := s.mysqlClient.QueryPlanInfoByName(input.Plan)
+		if err != nil {
+			return nil, err.Error(), -6
+		}
+		planId = plan.Id
+	} else if input.Quota <= 0 && input.Days <= 0 {
+		return nil, "quota, days or plan is must", -7
+	}
+
+	output := types.CreateActivationCodesOutput{
+		BatchId: uuid.New(),
+	}
+	infos := []types.ActivationCodeInfo{}
+
+	for i := 0; i < input.Count; i++ {
+		code, err := newActivationCode()
+		if err != nil {
+			return nil, err.Error(), -8
+		}
+		infos = append(infos, types.ActivationCodeInfo{
+			Code:       code,
+			BatchId:    output.BatchId,
+			Quota:      input.Quota,
+			Days:       input.Days,
+			PlanId:     planId,
+			Creator:    user.Username,
+			ExpireTime: time.Now().AddDate(0, 0, input.ExpireDays),
+			CreateTime: time.Now(),
+		})
+		output.Codes = append(output.Codes, code)
+	}
+
+	err = s.mysqlClient.InsertActivationCodes(infos)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to insert activation codes: %v", err)
+		return nil, err.Error(), -9
+	}
+
+	log.Infof(log.Fields{}, "%v create %v activation codes in batch %v", user.Username, input.Count, output.BatchId)
+	s.audit(req, user, types.AuditCreateCodes, output.BatchId.String(), nil, map[string]interface{}{
+		"count":       input.Count,
+		"quota":       input.Quota,
+		"days":        input.Days,
+		"plan":        input.Plan,
+		"expire_days": input.ExpireDays,
+	})
+
+	return output, "", 0
+}
+
+func (s *AuthServer) ActivationCodesRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.ActivationCodesInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	_, err = s.checkSuperUser(input.AuthCode)
+	if err != nil {
+		return nil, err.Error(), -3
+	}
+
+	return types.ActivationCodesOutput{
+		Codes: s.mysqlClient.QueryActivationCodes(input.BatchId),
+	}, "", 0
+}
+
+func (s *AuthServer) RedeemCodeRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.RedeemCodeInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	if input.AuthCode == "" {
+		return nil, "auth code is must", -3
+	}
+
+	user, err := authapi.UserInfo(authtypes.UserInfoInput{
+		AuthCode: input.AuthCode,
+	})
+	if err != nil {
+		return nil, err.Error(), -4
+	}
+
+	if user.VisitorOnly {
+		return nil, "operation not allowed", -5
+	}
+
+	var before interface{}
+	clientUser, err := s.mysqlClient.QueryUserInfoById(user.Id)
+	if err == nil {
+		before = *clientUser
+	}
+
+	code := strings.ToUpper(strings.TrimSpace(input.Code))
+	clientUser, codeInfo, err := s.mysqlClient.RedeemActivationCode(code, user.Id, user.Username)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to redeem %v for %v: %v", code, user.Username, err)
+		return nil, err.Error(), -6
+	}
+
+	if codeInfo.PlanId != uuid.Nil {
+		plan, err := s.mysqlClient.QueryPlanInfoById(codeInfo.PlanId)
+		if err == nil {
+			err = s.applyPlanEntitlements(clientUser.Username, plan)
+		}
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to apply plan of %v to %v: %v", code, user.Username, err)
+		}
+	}
+
+	log.Infof(log.Fields{}, "%v redeem activation code %v", user.Username, code)
+	s.audit(req, user, types.AuditRedeemCode, clientUser.Username, before, map[string]interface{}{
+		"code": code,
+		"user": clientUser,
+	})
+
+	return clientUser, "", 0
+}
+
+// scopedUserInfo returns the caller and the user info of username if the caller
+// can see it: super users see everyone, resellers their subtree and others only
+// themselves. An empty username means the caller, or the owner for a visitor.
+func (s *AuthServer) scopedUserInfo(authCode string, username string) (*authtypes.UserInfoOutput, *types.UserInfo, error) {
+	if authCode == "" {
+		return nil, nil, xerrors.Errorf("auth code is must")
+	}
+
+	user, err := authapi.UserInfo(authtypes.UserInfoInput{
+		AuthCode: authCode,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userId := user.Id
+	if user.VisitorOnly {
+		owner, err := authapi.VisitorOwner(authtypes.VisitorOwnerInput{
+			AuthCode: authCode,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		userId = owner.Owner
+	}
+
+	if user.SuperUser && username != "" {
+		clientUser, err := s.mysqlClient.QueryUserInfoByUsername(username)
+		return user, clientUser, err
+	}
+
+	clientUser, err := s.mysqlClient.QueryUserInfoById(userId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if username == "" || username == clientUser.Username {
+		return user, clientUser, nil
+	}
+
+	if clientUser.Reseller {
+		for _, subUser := range s.mysqlClient.QuerySubUserInfos(*clientUser) {
+			if subUser.Username == username {
+				return user, &subUser, nil
+			}
+		}
+	}
+
+	return nil, nil, xerrors.Errorf("operation not allowed")
+}
+
+// subUser checks whether username is in the subtree of the reseller
+func (s *AuthServer) subUser(reseller *types.UserInfo, username string) bool {
+	for _, subUser := range s.mysqlClient.QuerySubUserInfos(*reseller) {
+		if subUser.Id != reseller.Id && subUser.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *AuthServer) UsageRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.UsageInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	_, clientUser, err := s.scopedUserInfo(input.AuthCode, input.Username)
+	if err != nil {
+		return nil, err.Error(), -3
+	}
+
+	output := types.UsageOutput{
+		Username:  clientUser.Username,
+		StartTime: input.StartTime,
+		EndTime:   input.EndTime,
+		Totals:    map[string]uint64{},
+		Clients:   []types.ClientUsage{},
+	}
+	if output.EndTime.IsZero() {
+		output.EndTime = time.Now()
+	}
+	if output.StartTime.IsZero() {
+		output.StartTime = output.EndTime.AddDate(0, -1, 0)
+	}
+
+	clients := map[uuid.UUID]int{}
+	for _, usage := range s.mysqlClient.QueryUsageInfos(clientUser.Username, output.StartTime, output.EndTime) {
+		output.Totals[usage.Name] += usage.Value
+
+		i, ok := clients[usage.ClientId]
+		if !ok {
+			clientUsage := types.ClientUsage{
+				ClientId: usage.ClientId,
+				Counters: map[string]uint64{},
+			}
+			clientInfo, err := s.mysqlClient.QueryClientInfoByClientId(usage.ClientId)
+			if err == nil {
+				clientUsage.ClientSn = clientInfo.ClientSn
+			}
+			i = len(output.Clients)
+			clients[usage.ClientId] = i
+			output.Clients = append(output.Clients, clientUsage)
+		}
+		output.Clients[i].Counters[usage.Name] += usage.Value
+	}
+
+	return output, "", 0
+}
+
+func (s *AuthServer) UpdateVersionPolicyRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.UpdateVersionPolicyInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	user, err := s.checkSuperUser(input.AuthCode)
+	if err != nil {
+		return nil, err.Error(), -3
+	}
+
+	if input.Username != "" {
+		_, err = s.mysqlClient.QueryUserInfoByUsername(input.Username)
+		if err != nil {
+			return nil, err.Error(), -4
+		}
+	}
+
+	target := fmt.Sprintf("%v/%v", input.Username, input.NetworkType)
+	input.AuthCode = ""
+
+	if input.Remove {
+		err = s.mysqlClient.DeleteVersionPolicy(input.Username, input.NetworkType)
+		if err != nil {
+			return nil, err.Error(), -5
+		}
+		log.Infof(log.Fields{}, "%v remove version policy of %v", user.Username, target)
+		s.audit(req, user, types.AuditUpdateVersion, target, input, nil)
+		return nil, "", 0
+	}
+
+	if input.MinVersion == "" && len(input.Blocked) == 0 && input.Recommended == "" {
+		return nil, "empty version policy", -6
+	}
+
+	err = s.mysqlClient.UpdateVersionPolicy(types.VersionPolicyInfo{
+		Username:    input.Username,
+		NetworkType: input.NetworkType,
+		MinVersion:  input.MinVersion,
+		Blocked:     input.Blocked,
+		Recommended: input.Recommended,
+	})
+	if err != nil {
+		return nil, err.Error(), -7
+	}
+
+	log.Infof(log.Fields{}, "%v update version policy of %v", user.Username, target)
+	s.audit(req, user, types.AuditUpdateVersion, target, nil, input)
+
+	return nil, "", 0
+}
+
+func (s *AuthServer) VersionPoliciesRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.VersionPoliciesInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	_, err = s.checkSuperUser(input.AuthCode)
+	if err != nil {
+		return nil, err.Error(), -3
+	}
+
+	return types.VersionPoliciesOutput{
+		Policies: s.mysqlClient.QueryVersionPolicies(),
+	}, "", 0
+}
+
+// QueueCommandRequest queues a command to the client, or to every client of the
+// user when no client is given
+func (s *AuthServer) QueueCommandRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.QueueCommandInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	switch input.Command {
+	case types.CommandRestart, types.CommandReloadConfig, types.CommandCollectDiagnostics:
+	case types.CommandMessage:
+		if input.Payload == "" {
+			return nil, "message is must", -3
+		}
+	default:
+		return nil, "invalid command", -3
+	}
+
+	if input.ExpireMinutes <= 0 {
+		input.ExpireMinutes = defaultCommandExpireMinutes
+	}
+	if maxCommandExpireMinutes < input.ExpireMinutes {
+		return nil, fmt.Sprintf("expire minutes should not exceed %v", maxCommandExpireMinutes), -4
+	}
+
+	if input.ClientId != uuid.Nil {
+		clientInfo, err := s.mysqlClient.QueryClientInfoByClientId(input.ClientId)
+		if err != nil {
+			return nil, err.Error(), -5
+		}
+		if input.Username != "" && input.Username != clientInfo.ClientUser {
+			return nil, "client is not belong to user", -6
+		}
+		input.Username = clientInfo.ClientUser
+	}
+
+	user, clientUser, err := s.scopedUserInfo(input.AuthCode, input.Username)
+	if err != nil {
+		return nil, err.Error(), -7
+	}
+	if user.VisitorOnly {
+		return nil, "operation not allowed", -8
+	}
+
+	command := types.CommandInfo{
+		Id:         uuid.New(),
+		ClientUser: clientUser.Username,
+		ClientId:   input.ClientId,
+		Command:    input.Command,
+		Payload:    input.Payload,
+		Operator:   user.Username,
+		ExpireTime: time.Now().Add(time.Duration(input.ExpireMinutes) * time.Minute),
+		CreateTime: time.Now(),
+	}
+
+	err = s.mysqlClient.InsertCommandInfo(command)
+	if err != nil {
+		return nil, err.Error(), -9
+	}
+
+	log.Infof(log.Fields{}, "%v queue %v to %v / %v", user.Username, command.Command, command.ClientUser, command.ClientId)
+	s.audit(req, user, types.AuditQueueCommand, clientUser.Username, nil, command)
+
+	return command, "", 0
+}
+
+func (s *AuthServer) CommandsRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.CommandsInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	if input.ClientId != uuid.Nil {
+		clientInfo, err := s.mysqlClient.QueryClientInfoByClientId(input.ClientId)
+		if err != nil {
+			return nil, err.Error(), -3
+		}
+		input.Username = clientInfo.ClientUser
+	}
+
+	_, clientUser, err := s.scopedUserInfo(input.AuthCode, input.Username)
+	if err != nil {
+		return nil, err.Error(), -4
+	}
+
+	output := types.CommandsOutput{
+		Commands: []types.CommandDetail{},
+	}
+	for _, command := range s.mysqlClient.QueryCommandInfos(clientUser.Username, input.ClientId) {
+		detail := types.CommandDetail{
+			CommandInfo: command,
+			Expired:     !command.ExpireTime.After(time.Now()),
+			Deliveries:  []types.CommandDeliveryInfo{},
+		}
+		for _, delivery := range s.mysqlClient.QueryCommandDeliveries(command.Id) {
+			if input.ClientId == uuid.Nil || delivery.ClientId == input.ClientId {
+				detail.Deliveries = append(detail.Deliveries, delivery)
+			}
+		}
+		output.Commands = append(output.Commands, detail)
+	}
+
+	return output, "", 0
+}
+
+// ScheduleMaintenanceRequest schedules a maintenance window for the given clients,
+// or for every client of the user when no client is given
+func (s *AuthServer) ScheduleMaintenanceRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.ScheduleMaintenanceInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	user, clientUser, err := s.scopedUserInfo(input.AuthCode, input.Username)
+	if err != nil {
+		return nil, err.Error(), -3
+	}
+	if user.VisitorOnly {
+		return nil, "operation not allowed", -4
+	}
+
+	if input.StartTime.IsZero() {
+		input.StartTime = time.Now()
+	}
+	if !input.StartTime.Before(input.EndTime) || !time.Now().Before(input.EndTime) {
+		return nil, "invalid maintenance window", -5
+	}
+	if maxMaintenanceDuration < input.EndTime.Sub(input.StartTime) {
+		return nil, fmt.Sprintf("maintenance window should not exceed %v", maxMaintenanceDuration), -6
+	}
+
+	for _, id := range input.ClientIds {
+		clientInfo, err := s.mysqlClient.QueryClientInfoByClientId(id)
+		if err != nil {
+			return nil, err.Error(), -7
+		}
+		if clientInfo.ClientUser != clientUser.Username {
+			return nil, "client is not belong to user", -8
+		}
+	}
+
+	info := types.MaintenanceInfo{
+		Id:         uuid.New(),
+		ClientUser: clientUser.Username,
+		ClientIds:  input.ClientIds,
+		StartTime:  input.StartTime,
+		EndTime:    input.EndTime,
+		Reason:     input.Reason,
+		Operator:   user.Username,
+		CreateTime: time.Now(),
+	}
+	if info.ClientIds == nil {
+		info.ClientIds = []uuid.UUID{}
+	}
+
+	err = s.mysqlClient.InsertMaintenanceInfo(info)
+	if err != nil {
+		return nil, err.Error(), -9
+	}
+
+	log.Infof(log.Fields{}, "%v schedule maintenance of %v from %v to %v", user.Username, info.ClientUser, info.StartTime, info.EndTime)
+	s.audit(req, user, types.AuditScheduleMaint, info.ClientUser, nil, info)
+
+	return info, "", 0
+}
+
+func (s *AuthServer) CancelMaintenanceRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.CancelMaintenanceInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	info, err := s.mysqlClient.QueryMaintenanceInfoById(input.Id)
+	if err != nil {
+		return nil, err.Error(), -3
+	}
+
+	user, _, err := s.scopedUserInfo(input.AuthCode, info.ClientUser)
+	if err != nil {
+		return nil, err.Error(), -4
+	}
+	if user.VisitorOnly {
+		return nil, "operation not allowed", -5
+	}
+
+	err = s.mysqlClient.DeleteMaintenanceInfo(info.Id)
+	if err != nil {
+		return nil, err.Error(), -6
+	}
+
+	log.Infof(log.Fields{}, "%v cancel maintenance %v of %v", user.Username, info.Id, info.ClientUser)
+	s.audit(req, user, types.AuditCancelMaint, info.ClientUser, info, nil)
+
+	return nil, "", 0
+}
+
+func (s *AuthServer) MaintenancesRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.MaintenancesInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	_, clientUser, err := s.scopedUserInfo(input.AuthCode, input.Username)
+	if err != nil {
+		return nil, err.Error(), -3
+	}
+
+	return types.MaintenancesOutput{
+		Maintenances: s.mysqlClient.QueryMaintenanceInfos(clientUser.Username, time.Now()),
+	}, "", 0
+}
+
+func (s *AuthServer) BillingExportRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.BillingExportInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	_, err = s.checkSuperUser(input.AuthCode)
+	if err != nil {
+		return nil, err.Error(), -3
+	}
+
+	if input.EndTime.IsZero() {
+		input.EndTime = time.Now()
+	}
+	if input.StartTime.IsZero() {
+		input.StartTime = input.EndTime.AddDate(0, -1, 0)
+	}
+	if input.Format == "" {
+		input.Format = billing.FormatJson
+	}
+
+	statements, err := billing.NewBuilder(s.mysqlClient).
+		Statements(input.Username, input.StartTime, input.EndTime)
+	if err != nil {
+		return nil, err.Error(), -4
+	}
+
+	output := types.BillingExportOutput{
+		Format: input.Format,
+	}
+
+	switch input.Format {
+	case billing.FormatJson:
+		output.Statements = statements
+	case billing.FormatCsv:
+		output.Csv, err = billing.Csv(statements)
+		if err != nil {
+			return nil, err.Error(), -5
+		}
+	default:
+		return nil, "invalid format", -6
+	}
+
+	return output, "", 0
+}
+
+func (s *AuthServer) ClientInfoByIdRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.ClientInfoByIdInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	clientInfo, err := s.mysqlClient.QueryClientInfoByClientId(input.Id)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to find client info: %v", err)
+		return nil, err.Error(), -3
+	}
+
+	return clientInfo, "", 0
+}
+
+func (s *AuthServer) ClientInfoBySpecRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.ClientInfoBySpecInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	clientInfo, err := s.mysqlClient.QueryClientInfoByClientSn(input.Spec)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to find client info: %v", err)
+		return nil, err.Error(), -3
+	}
+
+	return clientInfo, "", 0
+}
+
+// parseTrustedProxies accepts single addresses and CIDR blocks
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, xerrors.Errorf("invalid proxy address %v", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func (s *AuthServer) trustedProxy(addr string) bool {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range s.proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIp returns the peer address of the request. X-Forwarded-For and X-Real-Ip
+// are only honoured when the peer is a trusted proxy, the forwarded chain is then
+// walked from the right up to the first address which is not a trusted proxy.
+func (s *AuthServer) remoteIp(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	if !s.trustedProxy(host) {
+		return host
+	}
+
+	forwarded := req.Header.Get("X-Forwarded-For")
+	if forwarded != "" {
+		addrs := strings.Split(forwarded, ",")
+		for i := len(addrs) - 1; 0 <= i; i-- {
+			addr := strings.TrimSpace(addrs[i])
+			if i == 0 || !s.trustedProxy(addr) {
+				return addr
+			}
+		}
+	}
+
+	realIp := strings.TrimSpace(req.Header.Get("X-Real-Ip"))
+	if realIp != "" {
+		return realIp
+	}
+
+	return host
+}
+
+func (s *AuthServer) audit(req *http.Request, user *authtypes.UserInfoOutput, action string, target string, before interface{}, after interface{}) {
+	info := types.AuditInfo{
+		Id:         uuid.New(),
+		Action:     action,
+		Target:     target,
+		SourceIp:   s.remoteIp(req),
+		CreateTime: time.Now(),
+	}
+
+	if user != nil {
+		info.Actor = user.Username
+		info.ActorId = user.Id
+	}
+	if before != nil {
+		b, _ := json.Marshal(before)
+		info.Before = string(b)
+	}
+	if after != nil {
+		b, _ := json.Marshal(after)
+		info.After = string(b)
+	}
+
+	err := s.mysqlClient.InsertAuditInfo(info)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to audit %v of %v on %v: %v", action, info.Actor, target, err)
+	}
+}
+
+func (s *AuthServer) AuditsRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.AuditsInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	_, err = s.checkSuperUser(input.AuthCode)
+	if err != nil {
+		return nil, err.Error(), -3
+	}
+
+	return types.AuditsOutput{
+		Audits: s.mysqlClient.QueryAuditInfos(input),
+	}, "", 0
+}
+
+func (s *AuthServer) checkSuperUser(authCode string) (*authtypes.UserInfoOutput, error) {
+	if authCode == "" {
+		return nil, xerrors.Errorf("auth code is must")
+	}
+
+	user, err := authapi.UserInfo(authtypes.UserInfoInput{
+		AuthCode: authCode,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.SuperUser || user.VisitorOnly {
+		return nil, xerrors.Errorf("operation not allowed")
+	}
+
+	return user, nil
+}
+
+func (s *AuthServer) IssueLicenseRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err.Error(), -1
+	}
+
+	input := types.IssueLicenseInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, err.Error(), -2
+	}
+
+	user, err := s.checkSuperUser(input.AuthCode)
+	if err != nil {
+		return nil, err.Error(), -3
+	}
+
+	if input.ClientSn == "" && input.Spec == "" {
+		return nil, "client sn or spec is must", -4
+	}
+
+	clientUser, err := s.mysqlClient.QueryUserInfoByUsername(input.Username)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to find client user: %v", err)
+		return nil, err.Error(), -5
+	}
+
+	license := types.OfflineLicense{
+		Type:       types.DocumentOfflineLicense,
+		Id:         uuid.New(),
+		Username:   clientUser.Username,
+		ClientSn:   input.ClientSn,
+		Spec:       input.Spec,
+		Quota:      clientUser.Quota,
+		IssueTime:  time.Now(),
+		ExpireTime: clientUser.ValidateDate,
+	}
+	if input.ValidateDate > 0 {
+		license.ExpireTime = time.Now().AddDate(0, 0, input.ValidateDate)
+	}
+
+	payload, _ := json.Marshal(license)
+	signature, err := s.licenseKey.Sign(payload)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to sign license: %v", err)
+		return nil, err.Error(), -6
+	}
+
+	log.Infof(log.Fields{}, "%v issue offline license %v to %v [%v / %v] expire at %v",
+		user.Username, license.Id, license.Username, license.ClientSn, license.Spec, license.ExpireTime)
+	s.audit(req, user, types.AuditIssueLicense, license.Username, nil, license)
+
+	return types.IssueLicenseOutput{
+		License: types.OfflineLicenseFile{
+			Payload:   base64.StdEncoding.EncodeToString(payload),
+			Signature: base64.StdEncoding.EncodeToString(signature),
+		},
+		PublicKey: string(s.licenseKey.GetPubkey()),
+	}, "", 0
 }
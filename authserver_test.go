@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	fbcredis "github.com/NpoolDevOps/fbc-license-service/redis"
+	types "github.com/NpoolDevOps/fbc-license-service/types"
+	"github.com/google/uuid"
+)
+
+func TestMaintenanceCovers(t *testing.T) {
+	client := types.ClientInfo{
+		Id:         uuid.New(),
+		ClientUser: "user",
+	}
+
+	tests := []struct {
+		name   string
+		info   types.MaintenanceInfo
+		covers bool
+	}{
+		{"all clients of user", types.MaintenanceInfo{ClientUser: "user"}, true},
+		{"empty client ids", types.MaintenanceInfo{ClientUser: "user", ClientIds: []uuid.UUID{}}, true},
+		{"listed client", types.MaintenanceInfo{ClientUser: "user", ClientIds: []uuid.UUID{uuid.New(), client.Id}}, true},
+		{"other clients", types.MaintenanceInfo{ClientUser: "user", ClientIds: []uuid.UUID{uuid.New()}}, false},
+		{"other user", types.MaintenanceInfo{ClientUser: "other"}, false},
+		{"client of other user", types.MaintenanceInfo{ClientUser: "other", ClientIds: []uuid.UUID{client.Id}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if covers := maintenanceCovers(test.info, client); covers != test.covers {
+				t.Fatalf("covers %v, expect %v", covers, test.covers)
+			}
+		})
+	}
+}
+
+func TestHeartbeatV0OutputSize(t *testing.T) {
+	// a 1024 bits session key encrypts at most 117 bytes in one block
+	const blockLen = 1024/8 - 11
+
+	for _, reason := range []string{
+		"", types.StopExpired, types.StopDisabled, types.StopClone, types.StopVersion, types.StopLease,
+	} {
+		b, _ := json.Marshal(types.HeartbeatV0Output{ShouldStop: true, StopReason: reason})
+		if blockLen < len(b) {
+			t.Fatalf("v0 output %v is %v bytes, longer than one block", string(b), len(b))
+		}
+	}
+}
+
+func TestConcurrentSources(t *testing.T) {
+	now := time.Now()
+	at := func(seconds int) time.Time {
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+
+	tests := []struct {
+		name     string
+		sources  []fbcredis.HeartbeatSource
+		first    string
+		sessions bool
+		ips      bool
+	}{
+		{"one source", []fbcredis.HeartbeatSource{
+			{Source: "a@1.1.1.1", First: at(0), Last: at(60)},
+		}, "", false, false},
+		{"restart", []fbcredis.HeartbeatSource{
+			{Source: "a@1.1.1.1", First: at(0), Last: at(30)},
+			{Source: "b@1.1.1.1", First: at(40), Last: at(60)},
+		}, "", false, false},
+		{"ip change", []fbcredis.HeartbeatSource{
+			{Source: "a@1.1.1.1", First: at(0), Last: at(30)},
+			{Source: "a@2.2.2.2", First: at(40), Last: at(60)},
+		}, "", false, false},
+		{"nat", []fbcredis.HeartbeatSource{
+			{Source: "a@1.1.1.1", First: at(0), Last: at(60)},
+			{Source: "a@2.2.2.2", First: at(10), Last: at(50)},
+		}, "", false, true},
+		{"clone", []fbcredis.HeartbeatSource{
+			{Source: "b@2.2.2.2", First: at(10), Last: at(60)},
+			{Source: "a@1.1.1.1", First: at(0), Last: at(60)},
+		}, "a", true, false},
+		{"clone after restart", []fbcredis.HeartbeatSource{
+			{Source: "a@1.1.1.1", First: at(0), Last: at(20)},
+			{Source: "b@1.1.1.1", First: at(30), Last: at(60)},
+			{Source: "c@2.2.2.2", First: at(40), Last: at(60)},
+		}, "b", true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first, sessions, ips := concurrentSources(test.sources)
+			if first != test.first || sessions != test.sessions || ips != test.ips {
+				t.Fatalf("concurrent sources %v %v %v, expect %v %v %v",
+					first, sessions, ips, test.first, test.sessions, test.ips)
+			}
+		})
+	}
+}
+
+func TestLicenseKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "license-key.pem")
+
+	_, err := loadLicenseKey(keyFile)
+	if err == nil {
+		t.Fatalf("a missing key should not be loaded")
+	}
+
+	key, err := generateLicenseKey(keyFile, 1024)
+	if err != nil {
+		t.Fatalf("fail to generate key: %v", err)
+	}
+
+	_, err = generateLicenseKey(keyFile, 1024)
+	if err == nil {
+		t.Fatalf("an existing key should not be overwritten")
+	}
+
+	loaded, err := loadLicenseKey(keyFile)
+	if err != nil {
+		t.Fatalf("fail to load key: %v", err)
+	}
+	if !bytes.Equal(loaded.GetPubkey(), key.GetPubkey()) {
+		t.Fatalf("loaded key is not the generated one")
+	}
+}
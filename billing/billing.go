@@ -0,0 +1,297 @@
+package billing
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	fbcmysql "github.com/NpoolDevOps/fbc-license-service/mysql"
+	types "github.com/NpoolDevOps/fbc-license-service/types"
+	"golang.org/x/xerrors"
+)
+
+const (
+	FormatJson = "json"
+	FormatCsv  = "csv"
+
+	UnknownNetwork = "unknown"
+)
+
+type Builder struct {
+	mysqlClient *fbcmysql.MysqlCli
+}
+
+func NewBuilder(mysqlCli *fbcmysql.MysqlCli) *Builder {
+	return &Builder{
+		mysqlClient: mysqlCli,
+	}
+}
+
+// Statements builds the statements of the range for the user, or for every user
+// when username is empty
+func (b *Builder) Statements(username string, start time.Time, end time.Time) ([]types.BillingStatement, error) {
+	if !start.Before(end) {
+		return nil, xerrors.Errorf("invalid range %v - %v", start, end)
+	}
+
+	var users []types.UserInfo
+	if username != "" {
+		userInfo, err := b.mysqlClient.QueryUserInfoByUsername(username)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *userInfo)
+	} else {
+		users = b.mysqlClient.QueryUserInfos()
+	}
+
+	statements := []types.BillingStatement{}
+	for _, userInfo := range users {
+		if end.Before(userInfo.CreateTime) {
+			continue
+		}
+		statements = append(statements, b.statement(userInfo, start, end))
+	}
+
+	sort.Slice(statements, func(i, j int) bool {
+		return statements[i].Username < statements[j].Username
+	})
+
+	return statements, nil
+}
+
+func overlapDays(from time.Time, to time.Time, start time.Time, end time.Time) float64 {
+	if from.IsZero() || from.Before(start) {
+		from = start
+	}
+	if to.IsZero() || end.Before(to) {
+		to = end
+	}
+	if !from.Before(to) {
+		return 0
+	}
+	return to.Sub(from).Hours() / 24
+}
+
+func networkName(networkType string) string {
+	if networkType == "" {
+		return UnknownNetwork
+	}
+	return networkType
+}
+
+// addSeatDays splits the registered period of the client at its network changes
+// and adds every part to the network the client was on
+func addSeatDays(seatDays map[string]float64, from time.Time, to time.Time, networkType string,
+	changes []types.NetworkChangeInfo, start time.Time, end time.Time) {
+	if 0 < len(changes) {
+		networkType = changes[0].OldNetwork
+	}
+	for _, change := range changes {
+		seatDays[networkName(networkType)] += overlapDays(from, change.CreateTime, start, end)
+		if from.Before(change.CreateTime) {
+			from = change.CreateTime
+		}
+		networkType = change.NewNetwork
+	}
+	seatDays[networkName(networkType)] += overlapDays(from, to, start, end)
+}
+
+func (b *Builder) seatDays(username string, start time.Time, end time.Time) map[string]float64 {
+	seatDays := map[string]float64{}
+
+	for _, clientInfo := range b.mysqlClient.QueryClientInfosByUser(username) {
+		addSeatDays(seatDays, clientInfo.CreateTime, end, clientInfo.NetworkType,
+			b.mysqlClient.QueryNetworkChanges(clientInfo.Id), start, end)
+	}
+
+	for _, info := range b.mysqlClient.QueryDeregisterInfos(username, start) {
+		addSeatDays(seatDays, info.RegisterTime, info.CreateTime, info.NetworkType,
+			b.mysqlClient.QueryNetworkChanges(info.ClientId), start, end)
+	}
+
+	for network, days := range seatDays {
+		if days == 0 {
+			delete(seatDays, network)
+			continue
+		}
+		seatDays[network] = math.Round(days*100) / 100
+	}
+
+	return seatDays
+}
+
+// auditUserInfo decodes the user stored in the before or after value of an
+// update_auth or redeem_code audit
+func auditUserInfo(action string, value string) (*types.UserInfo, error) {
+	if value == "" {
+		return nil, xerrors.Errorf("empty audit value")
+	}
+
+	if action == types.AuditRedeemCode {
+		redeem := struct {
+			User *types.UserInfo `json:"user"`
+		}{}
+		err := json.Unmarshal([]byte(value), &redeem)
+		if err != nil {
+			return nil, err
+		}
+		if redeem.User == nil {
+			return nil, xerrors.Errorf("no user in audit value")
+		}
+		return redeem.User, nil
+	}
+
+	userInfo := &types.UserInfo{}
+	err := json.Unmarshal([]byte(value), userInfo)
+	if err != nil {
+		return nil, err
+	}
+	return userInfo, nil
+}
+
+// history returns the license changes of the user up to end in time order
+func (b *Builder) history(username string, end time.Time) []types.AuditInfo {
+	audits := b.mysqlClient.QueryAuditInfos(types.AuditsInput{
+		Target:  username,
+		EndTime: end,
+	})
+
+	history := []types.AuditInfo{}
+	for i := len(audits) - 1; 0 <= i; i-- {
+		switch audits[i].Action {
+		case types.AuditUpdateAuth, types.AuditRedeemCode:
+			history = append(history, audits[i])
+		}
+	}
+
+	return history
+}
+
+func (b *Builder) statement(userInfo types.UserInfo, start time.Time, end time.Time) types.BillingStatement {
+	statement := types.BillingStatement{
+		Username:       userInfo.Username,
+		StartTime:      start,
+		EndTime:        end,
+		Quota:          userInfo.Quota,
+		ValidateDate:   userInfo.ValidateDate,
+		SeatDays:       b.seatDays(userInfo.Username, start, end),
+		LicensePeriods: []types.LicensePeriod{},
+		QuotaChanges:   []types.QuotaChange{},
+	}
+
+	// the license in effect at the start of the range is the result of the last
+	// change before it, or what the first change inside the range replaced
+	var initial *types.UserInfo
+	changed := false
+
+	for _, audit := range b.history(userInfo.Username, end) {
+		after, err := auditUserInfo(audit.Action, audit.After)
+		if err != nil {
+			continue
+		}
+
+		if audit.CreateTime.Before(start) {
+			initial = after
+			continue
+		}
+
+		if !changed && initial == nil {
+			initial, _ = auditUserInfo(audit.Action, audit.Before)
+		}
+		changed = true
+
+		statement.LicensePeriods = append(statement.LicensePeriods, types.LicensePeriod{
+			StartTime:    audit.CreateTime,
+			ValidateDate: after.ValidateDate,
+			Quota:        after.Quota,
+			PlanId:       after.PlanId,
+		})
+
+		oldQuota := 0
+		if before, err := auditUserInfo(audit.Action, audit.Before); err == nil {
+			oldQuota = before.Quota
+		}
+		if oldQuota != after.Quota {
+			statement.QuotaChanges = append(statement.QuotaChanges, types.QuotaChange{
+				Time:     audit.CreateTime,
+				Actor:    audit.Actor,
+				Action:   audit.Action,
+				OldQuota: oldQuota,
+				NewQuota: after.Quota,
+			})
+		}
+	}
+
+	if initial == nil && !changed {
+		initial = &userInfo
+	}
+	if initial != nil && initial.Username != "" {
+		periodStart := start
+		if start.Before(userInfo.CreateTime) {
+			periodStart = userInfo.CreateTime
+		}
+		statement.LicensePeriods = append([]types.LicensePeriod{{
+			StartTime:    periodStart,
+			ValidateDate: initial.ValidateDate,
+			Quota:        initial.Quota,
+			PlanId:       initial.PlanId,
+		}}, statement.LicensePeriods...)
+	}
+
+	return statement
+}
+
+// Csv flattens the statements to one row per user and network type, license
+// periods and quota changes are only counted, use the json format for them
+func Csv(statements []types.BillingStatement) (string, error) {
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+
+	err := w.Write([]string{
+		"username", "start_time", "end_time", "network_type", "seat_days",
+		"quota", "validate_date", "license_periods", "quota_changes",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, statement := range statements {
+		networks := []string{}
+		for network := range statement.SeatDays {
+			networks = append(networks, network)
+		}
+		sort.Strings(networks)
+		if len(networks) == 0 {
+			networks = append(networks, "")
+		}
+
+		for _, network := range networks {
+			err = w.Write([]string{
+				statement.Username,
+				statement.StartTime.Format(time.RFC3339),
+				statement.EndTime.Format(time.RFC3339),
+				network,
+				fmt.Sprintf("%.2f", statement.SeatDays[network]),
+				fmt.Sprintf("%v", statement.Quota),
+				statement.ValidateDate.Format(time.RFC3339),
+				fmt.Sprintf("%v", len(statement.LicensePeriods)),
+				fmt.Sprintf("%v", len(statement.QuotaChanges)),
+			})
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,89 @@
+package billing
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	types "github.com/NpoolDevOps/fbc-license-service/types"
+)
+
+func day(n int) time.Time {
+	return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, n)
+}
+
+func TestOverlapDays(t *testing.T) {
+	start := day(10)
+	end := day(20)
+
+	tests := []struct {
+		name string
+		from time.Time
+		to   time.Time
+		days float64
+	}{
+		{"inside", day(12), day(15), 3},
+		{"covering", day(5), day(25), 10},
+		{"starts before", day(5), day(12), 2},
+		{"ends after", day(18), day(25), 2},
+		{"before", day(1), day(5), 0},
+		{"after", day(21), day(25), 0},
+		{"touching start", day(5), day(10), 0},
+		{"zero from", time.Time{}, day(12), 2},
+		{"zero to", day(18), time.Time{}, 2},
+		{"reversed", day(15), day(12), 0},
+		{"half day", day(12), day(12).Add(12 * time.Hour), 0.5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if days := overlapDays(test.from, test.to, start, end); days != test.days {
+				t.Fatalf("overlap %v days, expect %v", days, test.days)
+			}
+		})
+	}
+}
+
+func TestAddSeatDays(t *testing.T) {
+	start := day(10)
+	end := day(20)
+
+	tests := []struct {
+		name        string
+		from        time.Time
+		to          time.Time
+		networkType string
+		changes     []types.NetworkChangeInfo
+		seatDays    map[string]float64
+	}{
+		{"no change", day(5), day(20), "mainnet", nil,
+			map[string]float64{"mainnet": 10}},
+		{"unknown network", day(12), day(20), "", nil,
+			map[string]float64{UnknownNetwork: 8}},
+		{"change inside range", day(5), day(20), "calibration", []types.NetworkChangeInfo{
+			{OldNetwork: "mainnet", NewNetwork: "calibration", CreateTime: day(15)},
+		}, map[string]float64{"mainnet": 5, "calibration": 5}},
+		{"change before range", day(1), day(20), "calibration", []types.NetworkChangeInfo{
+			{OldNetwork: "mainnet", NewNetwork: "calibration", CreateTime: day(5)},
+		}, map[string]float64{"mainnet": 0, "calibration": 10}},
+		{"change after range", day(5), day(25), "calibration", []types.NetworkChangeInfo{
+			{OldNetwork: "mainnet", NewNetwork: "calibration", CreateTime: day(22)},
+		}, map[string]float64{"mainnet": 10, "calibration": 0}},
+		{"back and forth", day(10), day(20), "mainnet", []types.NetworkChangeInfo{
+			{OldNetwork: "mainnet", NewNetwork: "calibration", CreateTime: day(12)},
+			{OldNetwork: "calibration", NewNetwork: "mainnet", CreateTime: day(16)},
+		}, map[string]float64{"mainnet": 6, "calibration": 4}},
+		{"deregistered inside range", day(5), day(13), "mainnet", nil,
+			map[string]float64{"mainnet": 3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			seatDays := map[string]float64{}
+			addSeatDays(seatDays, test.from, test.to, test.networkType, test.changes, start, end)
+			if !reflect.DeepEqual(seatDays, test.seatDays) {
+				t.Fatalf("seat days %v, expect %v", seatDays, test.seatDays)
+			}
+		})
+	}
+}
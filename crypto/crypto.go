@@ -1,12 +1,12 @@
 package crypto
 
 import (
-	// "crypto"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
-	// "crypto/sha256"
+	"crypto/sha256"
 	"crypto/x509"
-	// "encoding/hex"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 )
@@ -60,6 +60,32 @@ func NewRsaCryptoWithParam(pubkey []byte, privkey []byte) *RsaCrypto {
 	}
 }
 
+// NewRsaCryptoWithPrivkey 从 PEM 格式的私钥恢复完整的密钥对
+func NewRsaCryptoWithPrivkey(privkey []byte) (*RsaCrypto, error) {
+	block, _ := pem.Decode(privkey)
+	if block == nil {
+		return nil, errors.New("private key error")
+	}
+	priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	derPkix, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	pubkey := pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: derPkix,
+	})
+
+	return &RsaCrypto{
+		Pubkey:  pubkey,
+		Privkey: privkey,
+		Keylen:  priv.N.BitLen(),
+	}, nil
+}
+
 func (self *RsaCrypto) Encrypt(content []byte) ([]byte, error) {
 	//解密pem格式的公钥
 	block, _ := pem.Decode(self.Pubkey)
@@ -114,3 +140,102 @@ func (self *RsaCrypto) GetPubkey() []byte {
 func (self *RsaCrypto) GetPrivkey() []byte {
 	return self.Privkey
 }
+
+// EncryptBlocks 按公钥长度分段加密，输出为各段密文的拼接，
+// 内容不超过单段长度时与 Encrypt 的结果格式一致
+func (self *RsaCrypto) EncryptBlocks(content []byte) ([]byte, error) {
+	block, _ := pem.Decode(self.Pubkey)
+	if block == nil {
+		return nil, errors.New("public key error")
+	}
+	pubInterface, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := pubInterface.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key error")
+	}
+
+	blockLen := pub.Size() - 11
+	ciphertext := []byte{}
+	for start := 0; start < len(content); start += blockLen {
+		end := start + blockLen
+		if end > len(content) {
+			end = len(content)
+		}
+		data, err := rsa.EncryptPKCS1v15(rand.Reader, pub, content[start:end])
+		if err != nil {
+			return nil, err
+		}
+		ciphertext = append(ciphertext, data...)
+	}
+
+	return ciphertext, nil
+}
+
+// DecryptBlocks 对 EncryptBlocks 的结果按私钥长度分段解密
+func (self *RsaCrypto) DecryptBlocks(ciphertext []byte) ([]byte, error) {
+	block, _ := pem.Decode(self.Privkey)
+	if block == nil {
+		return nil, errors.New("private key error")
+	}
+	priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	blockLen := priv.Size()
+	if len(ciphertext)%blockLen != 0 {
+		return nil, errors.New("ciphertext length error")
+	}
+
+	content := []byte{}
+	for start := 0; start < len(ciphertext); start += blockLen {
+		data, err := rsa.DecryptPKCS1v15(rand.Reader, priv, ciphertext[start:start+blockLen])
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, data...)
+	}
+
+	return content, nil
+}
+
+// Sign 使用私钥对内容的 SHA256 摘要签名
+func (self *RsaCrypto) Sign(content []byte) ([]byte, error) {
+	block, _ := pem.Decode(self.Privkey)
+	if block == nil {
+		return nil, errors.New("private key error")
+	}
+	priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	hashed := sha256.Sum256(content)
+	return rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, hashed[:])
+}
+
+// Verify 使用公钥校验 Sign 生成的签名
+func (self *RsaCrypto) Verify(content []byte, signature []byte) error {
+	block, _ := pem.Decode(self.Pubkey)
+	if block == nil {
+		return errors.New("public key error")
+	}
+	pubInterface, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	pub, ok := pubInterface.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("public key error")
+	}
+	hashed := sha256.Sum256(content)
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], signature)
+}
+
+// Digest 返回内容 SHA256 摘要的十六进制编码
+func Digest(content string) string {
+	hashed := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hashed[:])
+}
@@ -0,0 +1,126 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptBlocks(t *testing.T) {
+	rsaCrypto := NewRsaCrypto(1024)
+	// 1024 位密钥单段最多加密 117 字节
+	blockLen := 1024/8 - 11
+
+	tests := []struct {
+		name   string
+		length int
+	}{
+		{"empty", 0},
+		{"short", 16},
+		{"one block", blockLen},
+		{"one block and one byte", blockLen + 1},
+		{"several blocks", blockLen*3 + 7},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := bytes.Repeat([]byte{'x'}, test.length)
+
+			ciphertext, err := rsaCrypto.EncryptBlocks(content)
+			if err != nil {
+				t.Fatalf("fail to encrypt: %v", err)
+			}
+			if len(ciphertext)%(1024/8) != 0 {
+				t.Fatalf("ciphertext length %v is not a multiple of the key size", len(ciphertext))
+			}
+
+			plaintext, err := rsaCrypto.DecryptBlocks(ciphertext)
+			if err != nil {
+				t.Fatalf("fail to decrypt: %v", err)
+			}
+			if !bytes.Equal(plaintext, content) {
+				t.Fatalf("decrypted %v bytes, expect %v", len(plaintext), len(content))
+			}
+		})
+	}
+}
+
+func TestDecryptBlocksCompatible(t *testing.T) {
+	rsaCrypto := NewRsaCrypto(1024)
+	content := []byte("fbc license")
+
+	ciphertext, err := rsaCrypto.Encrypt(content)
+	if err != nil {
+		t.Fatalf("fail to encrypt: %v", err)
+	}
+
+	plaintext, err := rsaCrypto.DecryptBlocks(ciphertext)
+	if err != nil {
+		t.Fatalf("fail to decrypt: %v", err)
+	}
+	if !bytes.Equal(plaintext, content) {
+		t.Fatalf("decrypted %q, expect %q", plaintext, content)
+	}
+}
+
+func TestDecryptBlocksInvalid(t *testing.T) {
+	rsaCrypto := NewRsaCrypto(1024)
+	other := NewRsaCrypto(1024)
+
+	ciphertext, err := other.EncryptBlocks([]byte("fbc license"))
+	if err != nil {
+		t.Fatalf("fail to encrypt: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+	}{
+		{"truncated", ciphertext[:len(ciphertext)-1]},
+		{"other key", ciphertext},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := rsaCrypto.DecryptBlocks(test.ciphertext)
+			if err == nil {
+				t.Fatalf("decrypt should fail")
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	rsaCrypto := NewRsaCrypto(1024)
+	other := NewRsaCrypto(1024)
+	content := []byte("fbc license")
+
+	signature, err := rsaCrypto.Sign(content)
+	if err != nil {
+		t.Fatalf("fail to sign: %v", err)
+	}
+	otherSignature, err := other.Sign(content)
+	if err != nil {
+		t.Fatalf("fail to sign: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		content   []byte
+		signature []byte
+		valid     bool
+	}{
+		{"valid", content, signature, true},
+		{"modified content", []byte("fbc licensf"), signature, false},
+		{"other key", content, otherSignature, false},
+		{"empty signature", content, nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewRsaCryptoWithParam(rsaCrypto.GetPubkey(), nil).Verify(test.content, test.signature)
+			if (err == nil) != test.valid {
+				t.Fatalf("verify returns %v, expect valid %v", err, test.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package fingerprint
+
+import (
+	"strings"
+
+	types "github.com/NpoolDevOps/fbc-license-service/types"
+)
+
+// Components is the number of components compared by Score
+const Components = 5
+
+func normalize(val string) string {
+	return strings.ToLower(strings.TrimSpace(val))
+}
+
+func matchValue(a string, b string) bool {
+	a = normalize(a)
+	return a != "" && a == normalize(b)
+}
+
+func matchSet(a []string, b []string) bool {
+	vals := map[string]struct{}{}
+	for _, val := range a {
+		if val = normalize(val); val != "" {
+			vals[val] = struct{}{}
+		}
+	}
+	for _, val := range b {
+		if _, ok := vals[normalize(val)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Score returns how many components of the two fingerprints match. MACs and
+// disks match when the two sets share at least one member, so that replacing
+// one disk or one NIC keeps the component matched.
+func Score(a types.HardwareFingerprint, b types.HardwareFingerprint) int {
+	score := 0
+
+	if matchValue(a.BoardSerial, b.BoardSerial) {
+		score += 1
+	}
+	if matchValue(a.ProductUuid, b.ProductUuid) {
+		score += 1
+	}
+	if matchValue(a.Cpu, b.Cpu) {
+		score += 1
+	}
+	if matchSet(a.Macs, b.Macs) {
+		score += 1
+	}
+	if matchSet(a.Disks, b.Disks) {
+		score += 1
+	}
+
+	return score
+}
@@ -0,0 +1,75 @@
+package fingerprint
+
+import (
+	"testing"
+
+	types "github.com/NpoolDevOps/fbc-license-service/types"
+)
+
+func TestScore(t *testing.T) {
+	stored := types.HardwareFingerprint{
+		BoardSerial: "BS-001",
+		ProductUuid: "4c4c4544-0042",
+		Cpu:         "Intel Xeon",
+		Macs:        []string{"00:11:22:33:44:55", "00:11:22:33:44:66"},
+		Disks:       []string{"disk-a", "disk-b"},
+	}
+
+	tests := []struct {
+		name     string
+		reported types.HardwareFingerprint
+		score    int
+	}{
+		{"same", stored, Components},
+		{"case and spaces", types.HardwareFingerprint{
+			BoardSerial: " bs-001 ",
+			ProductUuid: "4C4C4544-0042",
+			Cpu:         "intel xeon",
+			Macs:        []string{"00:11:22:33:44:55"},
+			Disks:       []string{"DISK-A"},
+		}, Components},
+		{"one nic and one disk replaced", types.HardwareFingerprint{
+			BoardSerial: "BS-001",
+			ProductUuid: "4c4c4544-0042",
+			Cpu:         "Intel Xeon",
+			Macs:        []string{"00:11:22:33:44:55", "aa:bb:cc:dd:ee:ff"},
+			Disks:       []string{"disk-b", "disk-c"},
+		}, Components},
+		{"board replaced", types.HardwareFingerprint{
+			BoardSerial: "BS-002",
+			ProductUuid: "4c4c4544-0099",
+			Cpu:         "Intel Xeon",
+			Macs:        []string{"00:11:22:33:44:55"},
+			Disks:       []string{"disk-a"},
+		}, 3},
+		{"other machine", types.HardwareFingerprint{
+			BoardSerial: "BS-002",
+			ProductUuid: "4c4c4544-0099",
+			Cpu:         "AMD EPYC",
+			Macs:        []string{"aa:bb:cc:dd:ee:ff"},
+			Disks:       []string{"disk-c"},
+		}, 0},
+		{"empty", types.HardwareFingerprint{}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if score := Score(stored, test.reported); score != test.score {
+				t.Fatalf("score %v, expect %v", score, test.score)
+			}
+			if score := Score(test.reported, stored); score != test.score {
+				t.Fatalf("reversed score %v, expect %v", score, test.score)
+			}
+		})
+	}
+}
+
+func TestScoreEmptyComponents(t *testing.T) {
+	// empty components never match, even against each other
+	a := types.HardwareFingerprint{Macs: []string{""}, Disks: []string{" "}}
+	b := types.HardwareFingerprint{Macs: []string{""}, Disks: []string{" "}}
+
+	if score := Score(a, b); score != 0 {
+		t.Fatalf("score %v, expect 0", score)
+	}
+}
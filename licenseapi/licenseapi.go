@@ -1,13 +1,19 @@
 package licenseapi
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	log "github.com/EntropyPool/entropy-logger"
+	"github.com/NpoolDevOps/fbc-license-service/crypto"
 	etcdcli "github.com/NpoolDevOps/fbc-license-service/etcdcli"
 	types "github.com/NpoolDevOps/fbc-license-service/types"
 	"github.com/NpoolRD/http-daemon"
+	"github.com/google/uuid"
 	"golang.org/x/xerrors"
+	"io/ioutil"
+	"sync"
+	"time"
 )
 
 const licenseDomain = "license.npool.top"
@@ -101,3 +107,235 @@ func ClientInfoBySpec(input types.ClientInfoBySpecInput) (*types.ClientInfoOutpu
 
 	return &output, err
 }
+
+// VerifyOfflineLicense checks an offline license file against the server public
+// key, its expire time and the local device spec / client sn, without network.
+func VerifyOfflineLicense(file types.OfflineLicenseFile, pubKey []byte, spec string, clientSn string) (*types.OfflineLicense, error) {
+	payload, err := base64.StdEncoding.DecodeString(file.Payload)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid license payload: %v", err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(file.Signature)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid license signature: %v", err)
+	}
+
+	err = crypto.NewRsaCryptoWithParam(pubKey, nil).Verify(payload, signature)
+	if err != nil {
+		return nil, xerrors.Errorf("fail to verify license signature: %v", err)
+	}
+
+	license := types.OfflineLicense{}
+	err = json.Unmarshal(payload, &license)
+	if err != nil {
+		return nil, err
+	}
+
+	if license.Type != types.DocumentOfflineLicense {
+		return nil, xerrors.Errorf("invalid license type %v", license.Type)
+	}
+
+	if time.Now().After(license.ExpireTime) {
+		return nil, xerrors.Errorf("license expired at %v", license.ExpireTime)
+	}
+
+	if license.Spec != "" && license.Spec != spec {
+		return nil, xerrors.Errorf("license is not issued for spec %v", spec)
+	}
+
+	if license.ClientSn != "" && license.ClientSn != clientSn {
+		return nil, xerrors.Errorf("license is not issued for client %v", clientSn)
+	}
+
+	return &license, nil
+}
+
+func Revocations(input types.RevocationsInput) (*types.RevocationsOutput, error) {
+	host, err := getLicenseHost()
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to get %v from etcd: %v", licenseDomain, err)
+		return nil, err
+	}
+
+	log.Infof(log.Fields{}, "req to http://%v%v", host, types.RevocationsAPI)
+
+	resp, err := httpdaemon.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(input).
+		Post(fmt.Sprintf("http://%v%v", host, types.RevocationsAPI))
+	if err != nil {
+		log.Errorf(log.Fields{}, "revocations error: %v", err)
+		return nil, err
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, xerrors.Errorf("NON-200 return")
+	}
+
+	apiResp, err := httpdaemon.ParseResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	output := types.RevocationsOutput{}
+	b, _ := json.Marshal(apiResp.Body)
+	err = json.Unmarshal(b, &output)
+
+	return &output, err
+}
+
+type revocationCache struct {
+	Version  int64                   `json:"version"`
+	Clients  map[uuid.UUID]time.Time `json:"clients"`
+	ClientSn map[string]time.Time    `json:"client_sn"`
+}
+
+// RevocationChecker keeps a local copy of the revocation list, it is refreshed
+// with deltas from the license server and optionally persisted to cacheFile so
+// that it still works without network access.
+type RevocationChecker struct {
+	pubKey    []byte
+	cacheFile string
+	mutex     sync.RWMutex
+	cache     revocationCache
+}
+
+func NewRevocationChecker(pubKey []byte, cacheFile string) *RevocationChecker {
+	checker := &RevocationChecker{
+		pubKey:    pubKey,
+		cacheFile: cacheFile,
+		cache: revocationCache{
+			Clients:  map[uuid.UUID]time.Time{},
+			ClientSn: map[string]time.Time{},
+		},
+	}
+
+	if cacheFile != "" {
+		b, err := ioutil.ReadFile(cacheFile)
+		if err == nil {
+			cache := revocationCache{}
+			err = json.Unmarshal(b, &cache)
+			if err == nil && cache.Clients != nil && cache.ClientSn != nil {
+				checker.cache = cache
+			}
+		}
+	}
+
+	return checker
+}
+
+func (c *RevocationChecker) Refresh() error {
+	c.mutex.RLock()
+	since := c.cache.Version
+	c.mutex.RUnlock()
+
+	output, err := Revocations(types.RevocationsInput{
+		Since: since,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.apply(output)
+}
+
+// apply verifies the signed list and merges it into the local copy
+func (c *RevocationChecker) apply(output *types.RevocationsOutput) error {
+	payload, err := base64.StdEncoding.DecodeString(output.Payload)
+	if err != nil {
+		return xerrors.Errorf("invalid revocations payload: %v", err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(output.Signature)
+	if err != nil {
+		return xerrors.Errorf("invalid revocations signature: %v", err)
+	}
+
+	err = crypto.NewRsaCryptoWithParam(c.pubKey, nil).Verify(payload, signature)
+	if err != nil {
+		return xerrors.Errorf("fail to verify revocations signature: %v", err)
+	}
+
+	list := types.RevocationList{}
+	err = json.Unmarshal(payload, &list)
+	if err != nil {
+		return err
+	}
+
+	if list.Type != types.DocumentRevocationList {
+		return xerrors.Errorf("invalid revocations type %v", list.Type)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// a replayed older list would un-revoke the clients revoked after it
+	if list.Version < c.cache.Version {
+		return xerrors.Errorf("revocations version %v is older than local version %v", list.Version, c.cache.Version)
+	}
+
+	if list.Since != 0 && list.Since != c.cache.Version {
+		return xerrors.Errorf("revocations since %v mismatch local version %v", list.Since, c.cache.Version)
+	}
+
+	if list.Since == 0 {
+		c.cache.Clients = map[uuid.UUID]time.Time{}
+		c.cache.ClientSn = map[string]time.Time{}
+	}
+
+	// sn are published as digests
+	for _, entry := range list.Entries {
+		if entry.Revoked {
+			c.cache.Clients[entry.ClientId] = entry.CreateTime
+			if entry.ClientSn != "" {
+				c.cache.ClientSn[entry.ClientSn] = entry.CreateTime
+			}
+		} else {
+			delete(c.cache.Clients, entry.ClientId)
+			delete(c.cache.ClientSn, entry.ClientSn)
+		}
+	}
+	c.cache.Version = list.Version
+
+	if c.cacheFile != "" {
+		b, _ := json.Marshal(c.cache)
+		err = ioutil.WriteFile(c.cacheFile, b, 0644)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to write revocation cache %v: %v", c.cacheFile, err)
+		}
+	}
+
+	return nil
+}
+
+// Run refreshes the revocation list every interval until the process exits
+func (c *RevocationChecker) Run(interval time.Duration) {
+	for {
+		err := c.Refresh()
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to refresh revocations: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (c *RevocationChecker) Version() int64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cache.Version
+}
+
+func (c *RevocationChecker) IsRevoked(id uuid.UUID) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	_, ok := c.cache.Clients[id]
+	return ok
+}
+
+func (c *RevocationChecker) IsRevokedSn(sn string) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	_, ok := c.cache.ClientSn[crypto.Digest(sn)]
+	return ok
+}
@@ -0,0 +1,195 @@
+package licenseapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/NpoolDevOps/fbc-license-service/crypto"
+	types "github.com/NpoolDevOps/fbc-license-service/types"
+	"github.com/google/uuid"
+)
+
+func sign(t *testing.T, rsaCrypto *crypto.RsaCrypto, document interface{}) (string, string) {
+	payload, err := json.Marshal(document)
+	if err != nil {
+		t.Fatalf("fail to marshal document: %v", err)
+	}
+	signature, err := rsaCrypto.Sign(payload)
+	if err != nil {
+		t.Fatalf("fail to sign document: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(payload), base64.StdEncoding.EncodeToString(signature)
+}
+
+func offlineLicenseFile(t *testing.T, rsaCrypto *crypto.RsaCrypto, document interface{}) types.OfflineLicenseFile {
+	payload, signature := sign(t, rsaCrypto, document)
+	return types.OfflineLicenseFile{
+		Payload:   payload,
+		Signature: signature,
+	}
+}
+
+func revocationsOutput(t *testing.T, rsaCrypto *crypto.RsaCrypto, document interface{}) *types.RevocationsOutput {
+	payload, signature := sign(t, rsaCrypto, document)
+	return &types.RevocationsOutput{
+		Payload:   payload,
+		Signature: signature,
+	}
+}
+
+func TestVerifyOfflineLicense(t *testing.T) {
+	rsaCrypto := crypto.NewRsaCrypto(1024)
+	other := crypto.NewRsaCrypto(1024)
+
+	license := types.OfflineLicense{
+		Type:       types.DocumentOfflineLicense,
+		Id:         uuid.New(),
+		Username:   "user",
+		ClientSn:   "sn",
+		Spec:       "spec",
+		Quota:      1,
+		IssueTime:  time.Now(),
+		ExpireTime: time.Now().Add(time.Hour),
+	}
+	anyClient := license
+	anyClient.ClientSn = ""
+	anyClient.Spec = ""
+	expired := license
+	expired.ExpireTime = time.Now().Add(-time.Hour)
+	untyped := license
+	untyped.Type = ""
+
+	valid := offlineLicenseFile(t, rsaCrypto, license)
+	tampered := valid
+	tampered.Payload = offlineLicenseFile(t, rsaCrypto, anyClient).Payload
+
+	tests := []struct {
+		name     string
+		file     types.OfflineLicenseFile
+		spec     string
+		clientSn string
+		valid    bool
+	}{
+		{"valid", valid, "spec", "sn", true},
+		{"any client", offlineLicenseFile(t, rsaCrypto, anyClient), "other", "other", true},
+		{"expired", offlineLicenseFile(t, rsaCrypto, expired), "spec", "sn", false},
+		{"other spec", valid, "other", "sn", false},
+		{"other client sn", valid, "spec", "other", false},
+		{"other key", offlineLicenseFile(t, other, license), "spec", "sn", false},
+		{"tampered payload", tampered, "spec", "sn", false},
+		{"without type", offlineLicenseFile(t, rsaCrypto, untyped), "spec", "sn", false},
+		{"revocation list", offlineLicenseFile(t, rsaCrypto, types.RevocationList{
+			Type:    types.DocumentRevocationList,
+			Entries: []types.RevocationInfo{},
+		}), "spec", "sn", false},
+		{"invalid payload", types.OfflineLicenseFile{Payload: "-", Signature: valid.Signature}, "spec", "sn", false},
+		{"invalid signature", types.OfflineLicenseFile{Payload: valid.Payload, Signature: "-"}, "spec", "sn", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := VerifyOfflineLicense(test.file, rsaCrypto.GetPubkey(), test.spec, test.clientSn)
+			if (err == nil) != test.valid {
+				t.Fatalf("verify returns %v, expect valid %v", err, test.valid)
+			}
+			if test.valid && output.Id != license.Id {
+				t.Fatalf("license id %v, expect %v", output.Id, license.Id)
+			}
+		})
+	}
+}
+
+func TestRevocationCheckerApply(t *testing.T) {
+	rsaCrypto := crypto.NewRsaCrypto(1024)
+	other := crypto.NewRsaCrypto(1024)
+	clientId := uuid.New()
+
+	revoked := types.RevocationList{
+		Type:    types.DocumentRevocationList,
+		Version: 5,
+		Entries: []types.RevocationInfo{
+			{Version: 5, ClientId: clientId, ClientSn: crypto.Digest("sn"), Revoked: true},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		output  *types.RevocationsOutput
+		applied bool
+		version int64
+	}{
+		{"newer delta", revocationsOutput(t, rsaCrypto, types.RevocationList{
+			Type: types.DocumentRevocationList, Version: 6, Since: 5, Entries: []types.RevocationInfo{},
+		}), true, 6},
+		{"same full list", revocationsOutput(t, rsaCrypto, revoked), true, 5},
+		{"older full list", revocationsOutput(t, rsaCrypto, types.RevocationList{
+			Type: types.DocumentRevocationList, Version: 3, Entries: []types.RevocationInfo{},
+		}), false, 5},
+		{"delta of other version", revocationsOutput(t, rsaCrypto, types.RevocationList{
+			Type: types.DocumentRevocationList, Version: 7, Since: 6, Entries: []types.RevocationInfo{},
+		}), false, 5},
+		{"without type", revocationsOutput(t, rsaCrypto, types.RevocationList{
+			Version: 6, Entries: []types.RevocationInfo{},
+		}), false, 5},
+		{"offline license", revocationsOutput(t, rsaCrypto, types.OfflineLicense{
+			Type: types.DocumentOfflineLicense, ExpireTime: time.Now().Add(time.Hour),
+		}), false, 5},
+		{"other key", revocationsOutput(t, other, types.RevocationList{
+			Type: types.DocumentRevocationList, Version: 6, Since: 5, Entries: []types.RevocationInfo{},
+		}), false, 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checker := NewRevocationChecker(rsaCrypto.GetPubkey(), "")
+			err := checker.apply(revocationsOutput(t, rsaCrypto, revoked))
+			if err != nil {
+				t.Fatalf("fail to apply initial list: %v", err)
+			}
+
+			err = checker.apply(test.output)
+			if (err == nil) != test.applied {
+				t.Fatalf("apply returns %v, expect applied %v", err, test.applied)
+			}
+			if checker.Version() != test.version {
+				t.Fatalf("version %v, expect %v", checker.Version(), test.version)
+			}
+			if !checker.IsRevoked(clientId) || !checker.IsRevokedSn("sn") {
+				t.Fatalf("client is not revoked any more")
+			}
+		})
+	}
+}
+
+func TestRevocationCheckerSn(t *testing.T) {
+	rsaCrypto := crypto.NewRsaCrypto(1024)
+	oldId := uuid.New()
+	newId := uuid.New()
+
+	checker := NewRevocationChecker(rsaCrypto.GetPubkey(), "")
+	lists := []types.RevocationList{
+		{Type: types.DocumentRevocationList, Version: 1, Entries: []types.RevocationInfo{
+			{Version: 1, ClientId: oldId, ClientSn: crypto.Digest("sn"), Revoked: true},
+		}},
+		// the sn is registered again by a new client
+		{Type: types.DocumentRevocationList, Version: 2, Since: 1, Entries: []types.RevocationInfo{
+			{Version: 2, ClientId: newId, ClientSn: crypto.Digest("sn"), Revoked: false},
+		}},
+	}
+
+	for _, list := range lists {
+		err := checker.apply(revocationsOutput(t, rsaCrypto, list))
+		if err != nil {
+			t.Fatalf("fail to apply list %v: %v", list.Version, err)
+		}
+	}
+
+	if !checker.IsRevoked(oldId) {
+		t.Fatalf("deregistered client is not revoked")
+	}
+	if checker.IsRevoked(newId) || checker.IsRevokedSn("sn") {
+		t.Fatalf("registered again sn is still revoked")
+	}
+}
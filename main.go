@@ -1,12 +1,141 @@
 package main
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
 	log "github.com/EntropyPool/entropy-logger"
+	"github.com/NpoolDevOps/fbc-license-service/billing"
+	fbcmysql "github.com/NpoolDevOps/fbc-license-service/mysql"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/xerrors"
-	"os"
 )
 
+const billingDateLayout = "2006-01-02"
+
+var billingCmd = &cli.Command{
+	Name:  "billing",
+	Usage: "Export billing statements of a date range",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "start",
+			Usage: "first day of the range, " + billingDateLayout,
+		},
+		&cli.StringFlag{
+			Name:  "end",
+			Usage: "day after the last day of the range, " + billingDateLayout + ", default today",
+		},
+		&cli.StringFlag{
+			Name:  "user",
+			Usage: "only export the statement of the user",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Value: billing.FormatCsv,
+			Usage: "csv or json",
+		},
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "output file, default stdout",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		config, err := readAuthServerConfig(cctx.String("config"))
+		if err != nil {
+			return xerrors.Errorf("cannot load config: %v", err)
+		}
+
+		end := time.Now()
+		if cctx.String("end") != "" {
+			end, err = time.ParseInLocation(billingDateLayout, cctx.String("end"), time.Local)
+			if err != nil {
+				return xerrors.Errorf("invalid end: %v", err)
+			}
+		}
+		start := end.AddDate(0, -1, 0)
+		if cctx.String("start") != "" {
+			start, err = time.ParseInLocation(billingDateLayout, cctx.String("start"), time.Local)
+			if err != nil {
+				return xerrors.Errorf("invalid start: %v", err)
+			}
+		}
+
+		mysqlCli := fbcmysql.NewMysqlCli(config.MysqlCfg)
+		if mysqlCli == nil {
+			return xerrors.Errorf("cannot create mysql client")
+		}
+
+		statements, err := billing.NewBuilder(mysqlCli).Statements(cctx.String("user"), start, end)
+		if err != nil {
+			return xerrors.Errorf("fail to build statements: %v", err)
+		}
+
+		var out []byte
+		switch cctx.String("format") {
+		case billing.FormatCsv:
+			text, err := billing.Csv(statements)
+			if err != nil {
+				return xerrors.Errorf("fail to format statements: %v", err)
+			}
+			out = []byte(text)
+		case billing.FormatJson:
+			out, err = json.MarshalIndent(statements, "", "  ")
+			if err != nil {
+				return xerrors.Errorf("fail to format statements: %v", err)
+			}
+			out = append(out, '\n')
+		default:
+			return xerrors.Errorf("invalid format %v", cctx.String("format"))
+		}
+
+		if cctx.String("output") == "" {
+			_, err = os.Stdout.Write(out)
+			return err
+		}
+		return ioutil.WriteFile(cctx.String("output"), out, 0644)
+	},
+}
+
+var keygenCmd = &cli.Command{
+	Name:  "keygen",
+	Usage: "Generate the license key",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "key file, default the key_file of the config",
+		},
+		&cli.IntFlag{
+			Name:  "bits",
+			Value: 2048,
+			Usage: "key size in bits",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		keyFile := cctx.String("output")
+		if keyFile == "" {
+			config, err := readAuthServerConfig(cctx.String("config"))
+			if err != nil {
+				return xerrors.Errorf("cannot load config: %v", err)
+			}
+			keyFile = config.LicenseCfg.KeyFile
+		}
+		if keyFile == "" {
+			return xerrors.Errorf("key file is must")
+		}
+
+		key, err := generateLicenseKey(keyFile, cctx.Int("bits"))
+		if err != nil {
+			return xerrors.Errorf("fail to generate license key: %v", err)
+		}
+
+		log.Infof(log.Fields{}, "generate license key to %v", keyFile)
+		_, err = os.Stdout.Write(key.GetPubkey())
+		return err
+	},
+}
+
 func main() {
 	app := &cli.App{
 		Name:                 "fbc-license-service",
@@ -19,6 +148,10 @@ func main() {
 				Value: "./fbc-license-service.conf",
 			},
 		},
+		Commands: []*cli.Command{
+			billingCmd,
+			keygenCmd,
+		},
 		Action: func(cctx *cli.Context) error {
 			configFile := cctx.String("config")
 			server := NewAuthServer(configFile)
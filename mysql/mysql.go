@@ -10,6 +10,7 @@ import (
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
 	"golang.org/x/xerrors"
+	"time"
 )
 
 type MysqlConfig struct {
@@ -32,6 +33,30 @@ const (
 	StatusDisable     = "disable"
 )
 
+const (
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	OutboxFailed    = "failed"
+)
+
+const (
+	CommandDelivered = "delivered"
+	CommandAcked     = "acked"
+)
+
+const (
+	LicenseModeNodeLocked = "node_locked"
+	LicenseModeFloating   = "floating"
+)
+
+var ErrQuotaExceeded = xerrors.Errorf("client quota exceeded")
+var ErrNetworkQuotaExceeded = xerrors.Errorf("network quota exceeded")
+var ErrTrialUsed = xerrors.Errorf("trial already used")
+var ErrQuotaAllocated = xerrors.Errorf("quota is below the quota allocated to sub users")
+var ErrUserNotAssigned = xerrors.Errorf("user is not assigned to the reseller")
+var ErrValidateDateExceeded = xerrors.Errorf("validate date exceeds the validate date of parent")
+var ErrCodeUnavailable = xerrors.Errorf("activation code is redeemed or expired")
+
 func NewMysqlCli(config MysqlConfig) *MysqlCli {
 	cli := &MysqlCli{
 		config: config,
@@ -63,6 +88,21 @@ func NewMysqlCli(config MysqlConfig) *MysqlCli {
 
 	log.Infof(log.Fields{}, "successful to create mysql db %v", cli.url)
 	db.SingularTable(true)
+
+	rc := db.AutoMigrate(&types.UserInfo{}, &types.ClientInfo{}, &types.EntitlementInfo{}, &types.PlanInfo{},
+		&types.DeregisterInfo{}, &types.StatusChangeInfo{},
+		&types.OnlineIntervalInfo{}, &types.AuditInfo{},
+		&types.WebhookOutboxInfo{}, &types.WebhookEventInfo{}, &types.ReminderInfo{},
+		&types.TrialInfo{}, &types.FingerprintInfo{}, &types.RevocationInfo{},
+		&types.RevocationVersionInfo{}, &types.ActivationCodeInfo{}, &types.CounterStateInfo{}, &types.UsageInfo{},
+		&types.NetworkQuotaInfo{}, &types.NetworkChangeInfo{}, &types.VersionPolicyInfo{},
+		&types.CommandInfo{}, &types.CommandDeliveryInfo{}, &types.MaintenanceInfo{})
+	if rc.Error != nil {
+		log.Errorf(log.Fields{}, "cannot migrate %v: %v", cli.url, rc.Error)
+		db.Close()
+		return nil
+	}
+
 	cli.db = db
 
 	return cli
@@ -124,10 +164,342 @@ func (cli *MysqlCli) InsertClientInfo(info types.ClientInfo) error {
 		return err
 	}
 
+	if info.LastSeen.IsZero() {
+		info.LastSeen = time.Now()
+	}
+
 	rc := cli.db.Create(&info)
 	return rc.Error
 }
 
+func (cli *MysqlCli) RegisterClientInfo(info types.ClientInfo) (*types.ClientInfo, error) {
+	_, err := cli.QueryStatusInfo(info.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	var user types.UserInfo
+	var count int
+
+	tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("username = ?", info.ClientUser).Find(&user).Count(&count)
+	if count == 0 {
+		tx.Rollback()
+		return nil, xerrors.Errorf("cannot find any value")
+	}
+
+	var exist types.ClientInfo
+	count = 0
+
+	tx.Where("client_sn = ?", info.ClientSn).Find(&exist).Count(&count)
+	if count > 0 {
+		tx.Rollback()
+		return &exist, nil
+	}
+
+	quota := user.Quota
+	if user.Reseller {
+		quota -= allocatedQuota(tx, user.Id, uuid.Nil)
+	}
+
+	tx.Model(&types.ClientInfo{}).Where("client_user = ?", info.ClientUser).Count(&count)
+	if user.LicenseMode != LicenseModeFloating && quota <= count {
+		tx.Rollback()
+		return nil, ErrQuotaExceeded
+	}
+
+	if user.LicenseMode != LicenseModeFloating && networkQuotaExceeded(tx, info) {
+		tx.Rollback()
+		return nil, ErrNetworkQuotaExceeded
+	}
+
+	// a zero datetime is rejected under NO_ZERO_DATE
+	if info.LastSeen.IsZero() {
+		info.LastSeen = time.Now()
+	}
+
+	rc := tx.Create(&info)
+	if rc.Error != nil {
+		tx.Rollback()
+		return nil, rc.Error
+	}
+
+	err = clearSnRevocation(tx, info)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	rc = tx.Model(&user).Update("count", count+1)
+	if rc.Error != nil {
+		tx.Rollback()
+		return nil, rc.Error
+	}
+
+	rc = tx.Commit()
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+
+	return &info, nil
+}
+
+// networkQuotaExceeded checks whether the network of info has no room for one more
+// client, networks without a quota of their own are only limited by the user quota
+func networkQuotaExceeded(db *gorm.DB, info types.ClientInfo) bool {
+	var quota types.NetworkQuotaInfo
+	var count int
+
+	db.Where("username = ? and network_type = ?", info.ClientUser, info.NetworkType).Find(&quota).Count(&count)
+	if count == 0 {
+		return false
+	}
+
+	db.Model(&types.ClientInfo{}).
+		Where("client_user = ? and network_type = ? and id <> ?", info.ClientUser, info.NetworkType, info.Id).
+		Count(&count)
+
+	return quota.Quota <= count
+}
+
+// UpdateClientNetwork moves the client to the network it reports now, the change
+// is rejected when the quota of the new network is used up
+func (cli *MysqlCli) UpdateClientNetwork(info types.ClientInfo, networkType string) error {
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	var user types.UserInfo
+	var count int
+
+	tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("username = ?", info.ClientUser).Find(&user).Count(&count)
+	if count == 0 {
+		tx.Rollback()
+		return xerrors.Errorf("cannot find any value")
+	}
+
+	oldNetwork := info.NetworkType
+	info.NetworkType = networkType
+
+	if user.LicenseMode != LicenseModeFloating && networkQuotaExceeded(tx, info) {
+		tx.Rollback()
+		return ErrNetworkQuotaExceeded
+	}
+
+	rc := tx.Model(&types.ClientInfo{}).Where("id = ?", info.Id).Updates(map[string]interface{}{
+		"network_type": networkType,
+		"modify_time":  time.Now(),
+	})
+	if rc.Error != nil {
+		tx.Rollback()
+		return rc.Error
+	}
+
+	rc = tx.Create(&types.NetworkChangeInfo{
+		Id:         uuid.New(),
+		ClientId:   info.Id,
+		ClientUser: info.ClientUser,
+		OldNetwork: oldNetwork,
+		NewNetwork: networkType,
+		CreateTime: time.Now(),
+	})
+	if rc.Error != nil {
+		tx.Rollback()
+		return rc.Error
+	}
+
+	return tx.Commit().Error
+}
+
+// QueryNetworkChanges returns the network changes of the client in time order
+func (cli *MysqlCli) QueryNetworkChanges(clientId uuid.UUID) []types.NetworkChangeInfo {
+	var infos []types.NetworkChangeInfo
+
+	cli.db.Where("client_id = ?", clientId).Order("create_time").Find(&infos)
+
+	return infos
+}
+
+func (cli *MysqlCli) QueryNetworkQuotas(username string) map[string]int {
+	var infos []types.NetworkQuotaInfo
+
+	cli.db.Where("username = ?", username).Find(&infos)
+
+	quotas := map[string]int{}
+	for _, info := range infos {
+		quotas[info.NetworkType] = info.Quota
+	}
+
+	return quotas
+}
+
+// UpdateNetworkQuotas replaces the network quotas of the user, an empty map
+// removes all of them
+func (cli *MysqlCli) UpdateNetworkQuotas(username string, quotas map[string]int) error {
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	rc := tx.Where("username = ?", username).Delete(&types.NetworkQuotaInfo{})
+	if rc.Error != nil {
+		tx.Rollback()
+		return rc.Error
+	}
+
+	for networkType, quota := range quotas {
+		rc = tx.Create(&types.NetworkQuotaInfo{
+			Id:          uuid.New(),
+			Username:    username,
+			NetworkType: networkType,
+			Quota:       quota,
+			CreateTime:  time.Now(),
+			ModifyTime:  time.Now(),
+		})
+		if rc.Error != nil {
+			tx.Rollback()
+			return rc.Error
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+func (cli *MysqlCli) DeregisterClientInfo(info types.ClientInfo, operator string) error {
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	var user types.UserInfo
+	var count int
+
+	tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("username = ?", info.ClientUser).Find(&user).Count(&count)
+
+	rc := tx.Where("id = ?", info.Id).Delete(&types.ClientInfo{})
+	if rc.Error != nil {
+		tx.Rollback()
+		return rc.Error
+	}
+	if rc.RowsAffected == 0 {
+		tx.Rollback()
+		return xerrors.Errorf("cannot find client")
+	}
+
+	rc = tx.Where("client_id = ?", info.Id).Delete(&types.EntitlementInfo{})
+	if rc.Error != nil {
+		tx.Rollback()
+		return rc.Error
+	}
+
+	err := insertRevocation(tx, types.RevocationInfo{
+		ClientId:   info.Id,
+		ClientSn:   info.ClientSn,
+		Revoked:    true,
+		CreateTime: time.Now(),
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if count > 0 {
+		tx.Model(&types.ClientInfo{}).Where("client_user = ?", info.ClientUser).Count(&count)
+		rc = tx.Model(&user).Update("count", count)
+		if rc.Error != nil {
+			tx.Rollback()
+			return rc.Error
+		}
+	}
+
+	rc = tx.Create(&types.DeregisterInfo{
+		Id:           uuid.New(),
+		ClientId:     info.Id,
+		ClientUser:   info.ClientUser,
+		ClientSn:     info.ClientSn,
+		Operator:     operator,
+		NetworkType:  info.NetworkType,
+		RegisterTime: info.CreateTime,
+		CreateTime:   time.Now(),
+	})
+	if rc.Error != nil {
+		tx.Rollback()
+		return rc.Error
+	}
+
+	return tx.Commit().Error
+}
+
+func (cli *MysqlCli) UpdateClientStatus(ids []uuid.UUID, status string, reason string, operator string) error {
+	_, err := cli.QueryStatusInfo(status)
+	if err != nil {
+		return err
+	}
+
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	for _, id := range ids {
+		var info types.ClientInfo
+		var count int
+
+		tx.Set("gorm:query_option", "FOR UPDATE").
+			Where("id = ?", id).Find(&info).Count(&count)
+		if count == 0 {
+			tx.Rollback()
+			return xerrors.Errorf("cannot find client %v", id)
+		}
+
+		rc := tx.Model(&info).Updates(map[string]interface{}{
+			"status":      status,
+			"modify_time": time.Now(),
+		})
+		if rc.Error != nil {
+			tx.Rollback()
+			return rc.Error
+		}
+
+		if (status == StatusDisable) != (info.Status == StatusDisable) {
+			err = insertRevocation(tx, types.RevocationInfo{
+				ClientId:   id,
+				ClientSn:   info.ClientSn,
+				Revoked:    status == StatusDisable,
+				CreateTime: time.Now(),
+			})
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		rc = tx.Create(&types.StatusChangeInfo{
+			Id:         uuid.New(),
+			ClientId:   id,
+			OldStatus:  info.Status,
+			NewStatus:  status,
+			Reason:     reason,
+			Operator:   operator,
+			CreateTime: time.Now(),
+		})
+		if rc.Error != nil {
+			tx.Rollback()
+			return rc.Error
+		}
+	}
+
+	return tx.Commit().Error
+}
+
 func (cli *MysqlCli) QueryClientInfoByClientSn(sn string) (*types.ClientInfo, error) {
 	var info types.ClientInfo
 	var count int
@@ -190,6 +562,15 @@ func (cli *MysqlCli) QueryClientInfosByUser(username string) []types.ClientInfo
 	return infos
 }
 
+// QueryDeregisterInfos returns the clients of the user deregistered after since
+func (cli *MysqlCli) QueryDeregisterInfos(username string, since time.Time) []types.DeregisterInfo {
+	var infos []types.DeregisterInfo
+
+	cli.db.Where("client_user = ? and create_time >= ?", username, since).Find(&infos)
+
+	return infos
+}
+
 func (cli *MysqlCli) QueryClientStatus(id uuid.UUID) *types.ClientInfo {
 	var info types.ClientInfo
 	var count int
@@ -202,8 +583,1300 @@ func (cli *MysqlCli) QueryClientStatus(id uuid.UUID) *types.ClientInfo {
 	return &info
 }
 
+// allocatedQuota sums the quota a reseller allocated to its sub users
+func allocatedQuota(db *gorm.DB, parentId uuid.UUID, exclude uuid.UUID) int {
+	var allocated int
+
+	row := db.Model(&types.UserInfo{}).
+		Where("parent_id = ? and id <> ?", parentId, exclude).
+		Select("coalesce(sum(quota), 0)").Row()
+	if row != nil {
+		row.Scan(&allocated)
+	}
+
+	return allocated
+}
+
+// saveUserInfo saves the license of the user inside tx. The user cannot get less
+// quota than it allocated to its sub users, and a sub user is limited by the pool
+// and the validate date of its parent, which is locked for the check.
+func saveUserInfo(tx *gorm.DB, info types.UserInfo) error {
+	if info.Quota < 0 {
+		return xerrors.Errorf("invalid quota %v", info.Quota)
+	}
+
+	if allocatedQuota(tx, info.Id, uuid.Nil) > info.Quota {
+		return ErrQuotaAllocated
+	}
+
+	if info.ParentId != uuid.Nil {
+		var parent types.UserInfo
+		var count int
+
+		tx.Set("gorm:query_option", "FOR UPDATE").
+			Where("id = ?", info.ParentId).Find(&parent).Count(&count)
+		if count == 0 {
+			return xerrors.Errorf("cannot find parent of %v", info.Username)
+		}
+
+		allocated := allocatedQuota(tx, parent.Id, info.Id) + info.Quota
+		tx.Model(&types.ClientInfo{}).Where("client_user = ?", parent.Username).Count(&count)
+		if parent.Quota < allocated+count {
+			return ErrQuotaExceeded
+		}
+
+		if info.ValidateDate.After(parent.ValidateDate) {
+			return ErrValidateDateExceeded
+		}
+	}
+
+	return tx.Save(&info).Error
+}
+
+// descendant checks whether info is under the ancestor, at any depth
+func descendant(db *gorm.DB, ancestorId uuid.UUID, info types.UserInfo) bool {
+	visited := map[uuid.UUID]bool{}
+
+	for info.ParentId != uuid.Nil && !visited[info.Id] {
+		if info.ParentId == ancestorId {
+			return true
+		}
+		visited[info.Id] = true
+
+		var parent types.UserInfo
+		var count int
+
+		db.Where("id = ?", info.ParentId).Find(&parent).Count(&count)
+		if count == 0 {
+			return false
+		}
+		info = parent
+	}
+
+	return false
+}
+
+// AllocateQuota saves a user in the subtree of a reseller, see saveUserInfo for
+// the limits. A reseller cannot create users, they are assigned to it by a super
+// user, and it cannot move them to another parent.
+func (cli *MysqlCli) AllocateQuota(parentId uuid.UUID, info types.UserInfo) error {
+	if info.Quota < 0 {
+		return xerrors.Errorf("invalid quota %v", info.Quota)
+	}
+
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	var exist types.UserInfo
+	var count int
+
+	tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("id = ?", info.Id).Find(&exist).Count(&count)
+	if count == 0 || !descendant(tx, parentId, exist) {
+		tx.Rollback()
+		return ErrUserNotAssigned
+	}
+
+	info.ParentId = exist.ParentId
+	err := saveUserInfo(tx, info)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// QuerySubUserInfos returns the user and all users under it
+func (cli *MysqlCli) QuerySubUserInfos(info types.UserInfo) []types.UserInfo {
+	infos := []types.UserInfo{info}
+	visited := map[uuid.UUID]bool{info.Id: true}
+
+	for i := 0; i < len(infos); i++ {
+		var children []types.UserInfo
+		cli.db.Where("parent_id = ?", infos[i].Id).Find(&children)
+		for _, child := range children {
+			if visited[child.Id] {
+				continue
+			}
+			visited[child.Id] = true
+			infos = append(infos, child)
+		}
+	}
+
+	return infos
+}
+
+// UpdateAuth saves the license of the user, see saveUserInfo for the limits
 func (cli *MysqlCli) UpdateAuth(info types.UserInfo) error {
 	log.Infof(log.Fields{}, "update auth %v", info)
-	rc := cli.db.Save(&info)
-	return rc.Error
+
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	var exist types.UserInfo
+	tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", info.Id).Find(&exist)
+
+	err := saveUserInfo(tx, info)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func (cli *MysqlCli) QueryEntitlements(username string) []types.EntitlementInfo {
+	var infos []types.EntitlementInfo
+
+	cli.db.Where("username = ?", username).Find(&infos)
+
+	return infos
+}
+
+func (cli *MysqlCli) QueryClientEntitlements(username string, clientId uuid.UUID) map[string]string {
+	var infos []types.EntitlementInfo
+
+	cli.db.Where("username = ? and client_id in (?)", username,
+		[]string{uuid.Nil.String(), clientId.String()}).Find(&infos)
+
+	entitlements := map[string]string{}
+	for _, info := range infos {
+		if info.ClientId == uuid.Nil {
+			if _, ok := entitlements[info.Name]; ok {
+				continue
+			}
+		}
+		entitlements[info.Name] = info.Value
+	}
+
+	return entitlements
+}
+
+func (cli *MysqlCli) UpdateEntitlement(info types.EntitlementInfo) error {
+	var exist types.EntitlementInfo
+	var count int
+
+	cli.db.Where("username = ? and client_id = ? and name = ?",
+		info.Username, info.ClientId, info.Name).Find(&exist).Count(&count)
+
+	if info.Value == "" {
+		if count == 0 {
+			return nil
+		}
+		return cli.db.Delete(&exist).Error
+	}
+
+	if count == 0 {
+		info.Id = uuid.New()
+		info.CreateTime = time.Now()
+		info.ModifyTime = time.Now()
+		return cli.db.Create(&info).Error
+	}
+
+	exist.Value = info.Value
+	exist.PlanId = info.PlanId
+	exist.ModifyTime = time.Now()
+	return cli.db.Save(&exist).Error
+}
+
+// ReplacePlanEntitlements makes the plan sourced entitlements of the user equal to
+// the entitlements of the plan, a nil plan removes them. Entitlements set by hand
+// are kept unless the plan sets the same name.
+func (cli *MysqlCli) ReplacePlanEntitlements(username string, plan *types.PlanInfo) error {
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	err := replacePlanEntitlements(tx, username, plan)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func replacePlanEntitlements(tx *gorm.DB, username string, plan *types.PlanInfo) error {
+	entitlements := map[string]string{}
+	planId := uuid.Nil
+	if plan != nil {
+		entitlements = plan.Entitlements
+		planId = plan.Id
+	}
+
+	var infos []types.EntitlementInfo
+	tx.Where("username = ? and client_id = ?", username, uuid.Nil).Find(&infos)
+
+	exists := map[string]types.EntitlementInfo{}
+	for _, info := range infos {
+		if _, ok := entitlements[info.Name]; ok {
+			exists[info.Name] = info
+			continue
+		}
+		if info.PlanId == uuid.Nil {
+			continue
+		}
+		rc := tx.Delete(&info)
+		if rc.Error != nil {
+			return rc.Error
+		}
+	}
+
+	for name, value := range entitlements {
+		exist, ok := exists[name]
+		if !ok {
+			exist = types.EntitlementInfo{
+				Id:         uuid.New(),
+				Username:   username,
+				ClientId:   uuid.Nil,
+				Name:       name,
+				CreateTime: time.Now(),
+			}
+		}
+		exist.Value = value
+		exist.PlanId = planId
+		exist.ModifyTime = time.Now()
+
+		var rc *gorm.DB
+		if ok {
+			rc = tx.Save(&exist)
+		} else {
+			rc = tx.Create(&exist)
+		}
+		if rc.Error != nil {
+			return rc.Error
+		}
+	}
+
+	return nil
+}
+
+// ApplyPlan saves the user with the quota and entitlements of the updated plan in
+// one transaction, see saveUserInfo for the limits
+func (cli *MysqlCli) ApplyPlan(info types.UserInfo, plan types.PlanInfo) error {
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	var exist types.UserInfo
+	tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", info.Id).Find(&exist)
+
+	err := saveUserInfo(tx, info)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = replacePlanEntitlements(tx, info.Username, &plan)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func (cli *MysqlCli) UpdateClientVersion(id uuid.UUID, clientVersion string) error {
+	rc := cli.db.Model(&types.ClientInfo{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"client_version": clientVersion,
+		"modify_time":    time.Now(),
+	})
+	return rc.Error
+}
+
+func parseVersionPolicyInfo(info *types.VersionPolicyInfo) {
+	info.Blocked = []string{}
+	if info.BlockedText != "" {
+		json.Unmarshal([]byte(info.BlockedText), &info.Blocked)
+	}
+}
+
+// UpdateVersionPolicy creates or replaces the policy of the username and network
+// pair, an empty username or network applies to all of them
+func (cli *MysqlCli) UpdateVersionPolicy(info types.VersionPolicyInfo) error {
+	b, err := json.Marshal(info.Blocked)
+	if err != nil {
+		return err
+	}
+	info.BlockedText = string(b)
+
+	var exist types.VersionPolicyInfo
+	var count int
+
+	cli.db.Where("username = ? and network_type = ?", info.Username, info.NetworkType).Find(&exist).Count(&count)
+	if count == 0 {
+		info.Id = uuid.New()
+		info.CreateTime = time.Now()
+		info.ModifyTime = time.Now()
+		return cli.db.Create(&info).Error
+	}
+
+	info.Id = exist.Id
+	info.CreateTime = exist.CreateTime
+	info.ModifyTime = time.Now()
+	return cli.db.Save(&info).Error
+}
+
+func (cli *MysqlCli) DeleteVersionPolicy(username string, networkType string) error {
+	rc := cli.db.Where("username = ? and network_type = ?", username, networkType).Delete(&types.VersionPolicyInfo{})
+	if rc.Error != nil {
+		return rc.Error
+	}
+	if rc.RowsAffected == 0 {
+		return xerrors.Errorf("cannot find version policy")
+	}
+	return nil
+}
+
+func (cli *MysqlCli) QueryVersionPolicies() []types.VersionPolicyInfo {
+	var infos []types.VersionPolicyInfo
+
+	cli.db.Order("username, network_type").Find(&infos)
+	for i := range infos {
+		parseVersionPolicyInfo(&infos[i])
+	}
+
+	return infos
+}
+
+// QueryVersionPolicy returns the most specific policy of the client, a policy of
+// the user wins over a policy of the network, and both win over the global one
+func (cli *MysqlCli) QueryVersionPolicy(username string, networkType string) (*types.VersionPolicyInfo, error) {
+	var infos []types.VersionPolicyInfo
+
+	cli.db.Where("username in (?, '') and network_type in (?, '')", username, networkType).Find(&infos)
+
+	var policy *types.VersionPolicyInfo
+	best := -1
+	for i, info := range infos {
+		score := 0
+		if info.Username != "" {
+			score += 2
+		}
+		if info.NetworkType != "" {
+			score += 1
+		}
+		if best < score {
+			best = score
+			policy = &infos[i]
+		}
+	}
+	if policy == nil {
+		return nil, xerrors.Errorf("cannot find version policy")
+	}
+	parseVersionPolicyInfo(policy)
+
+	return policy, nil
+}
+
+func (cli *MysqlCli) InsertPlanInfo(info types.PlanInfo) error {
+	b, err := json.Marshal(info.Entitlements)
+	if err != nil {
+		return err
+	}
+	info.EntitlementsText = string(b)
+
+	rc := cli.db.Create(&info)
+	return rc.Error
+}
+
+func (cli *MysqlCli) UpdatePlanInfo(info types.PlanInfo) error {
+	b, err := json.Marshal(info.Entitlements)
+	if err != nil {
+		return err
+	}
+	info.EntitlementsText = string(b)
+
+	rc := cli.db.Save(&info)
+	return rc.Error
+}
+
+func parsePlanInfo(info *types.PlanInfo) {
+	info.Entitlements = map[string]string{}
+	if info.EntitlementsText != "" {
+		json.Unmarshal([]byte(info.EntitlementsText), &info.Entitlements)
+	}
+}
+
+func (cli *MysqlCli) QueryPlanInfoById(id uuid.UUID) (*types.PlanInfo, error) {
+	var info types.PlanInfo
+	var count int
+
+	cli.db.Where("id = ?", id).Find(&info).Count(&count)
+	if count == 0 {
+		return nil, xerrors.Errorf("cannot find plan")
+	}
+	parsePlanInfo(&info)
+
+	return &info, nil
+}
+
+func (cli *MysqlCli) QueryPlanInfoByName(name string) (*types.PlanInfo, error) {
+	var info types.PlanInfo
+	var count int
+
+	cli.db.Where("name = ?", name).Find(&info).Count(&count)
+	if count == 0 {
+		return nil, xerrors.Errorf("cannot find plan")
+	}
+	parsePlanInfo(&info)
+
+	return &info, nil
+}
+
+func (cli *MysqlCli) QueryPlanInfos() []types.PlanInfo {
+	var infos []types.PlanInfo
+
+	cli.db.Find(&infos)
+	for i := range infos {
+		parsePlanInfo(&infos[i])
+	}
+
+	return infos
+}
+
+func (cli *MysqlCli) QueryUserInfosByPlan(planId uuid.UUID) []types.UserInfo {
+	var infos []types.UserInfo
+
+	cli.db.Where("plan_id = ?", planId).Find(&infos)
+
+	return infos
+}
+
+func (cli *MysqlCli) UpdateClientLastSeen(id uuid.UUID, lastSeen time.Time) error {
+	rc := cli.db.Model(&types.ClientInfo{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"last_seen": lastSeen,
+		"status":    gorm.Expr("if(status = ?, ?, status)", StatusOffline, StatusOnline),
+	})
+	return rc.Error
+}
+
+// ReapOfflineClients marks the clients not seen since lastSeen offline, except the
+// clients under a maintenance window open at now, of all clients of the user when
+// the window has no client ids, or listing the client in its client ids
+func (cli *MysqlCli) ReapOfflineClients(lastSeen time.Time, now time.Time) (int64, error) {
+	// last_seen is null for the clients created before the column exists
+	rc := cli.db.Model(&types.ClientInfo{}).
+		Where("status = ? and (last_seen < ? or last_seen is null)", StatusOnline, lastSeen).
+		Where("not exists (select 1 from maintenance_info m where m.client_user = client_info.client_user "+
+			"and m.start_time <= ? and m.end_time > ? "+
+			"and (m.client_ids in ('', 'null', '[]') or m.client_ids like concat('%\"', client_info.id, '\"%')))",
+			now, now).
+		Updates(map[string]interface{}{
+			"status":      StatusOffline,
+			"modify_time": time.Now(),
+		})
+	return rc.RowsAffected, rc.Error
+}
+
+// RecordOnlineInterval extends the latest online interval of the client, or opens
+// a new one when the previous heartbeat is older than gap
+// RecordOnlineInterval extends the latest interval of the client or starts a new
+// one, the latest interval is locked so that concurrent heartbeats of the client
+// neither lose an extension nor start two intervals
+func (cli *MysqlCli) RecordOnlineInterval(clientId uuid.UUID, now time.Time, gap time.Duration) error {
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	var info types.OnlineIntervalInfo
+
+	tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("client_id = ?", clientId).Order("end_time desc").Limit(1).Find(&info)
+	if info.Id != uuid.Nil && !info.EndTime.Before(now.Add(-gap)) {
+		if info.EndTime.Before(now) {
+			rc := tx.Model(&info).Update("end_time", now)
+			if rc.Error != nil {
+				tx.Rollback()
+				return rc.Error
+			}
+		}
+		return tx.Commit().Error
+	}
+
+	rc := tx.Create(&types.OnlineIntervalInfo{
+		Id:        uuid.New(),
+		ClientId:  clientId,
+		StartTime: now,
+		EndTime:   now,
+	})
+	if rc.Error != nil {
+		tx.Rollback()
+		return rc.Error
+	}
+
+	return tx.Commit().Error
+}
+
+func (cli *MysqlCli) QueryOnlineIntervals(clientId uuid.UUID, start time.Time, end time.Time) []types.OnlineIntervalInfo {
+	var infos []types.OnlineIntervalInfo
+
+	cli.db.Where("client_id = ? and end_time >= ? and start_time <= ?", clientId, start, end).
+		Order("start_time").Find(&infos)
+
+	return infos
+}
+
+func (cli *MysqlCli) InsertAuditInfo(info types.AuditInfo) error {
+	rc := cli.db.Create(&info)
+	return rc.Error
+}
+
+func (cli *MysqlCli) QueryAuditInfos(input types.AuditsInput) []types.AuditInfo {
+	var infos []types.AuditInfo
+
+	db := cli.db
+	if input.Actor != "" {
+		db = db.Where("actor = ?", input.Actor)
+	}
+	if input.Target != "" {
+		db = db.Where("target = ?", input.Target)
+	}
+	if input.Action != "" {
+		db = db.Where("action = ?", input.Action)
+	}
+	if !input.StartTime.IsZero() {
+		db = db.Where("create_time >= ?", input.StartTime)
+	}
+	if !input.EndTime.IsZero() {
+		db = db.Where("create_time <= ?", input.EndTime)
+	}
+	if input.Limit > 0 {
+		db = db.Limit(input.Limit)
+	}
+
+	db.Order("create_time desc").Find(&infos)
+
+	return infos
+}
+
+func (cli *MysqlCli) InsertWebhookOutbox(info types.WebhookOutboxInfo) error {
+	rc := cli.db.Create(&info)
+	return rc.Error
+}
+
+// InsertWebhookEvent marks the transition and stores its outbox in one
+// transaction, it returns false if the transition is already marked
+func (cli *MysqlCli) InsertWebhookEvent(info types.WebhookEventInfo, outboxes []types.WebhookOutboxInfo) (bool, error) {
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+
+	var count int
+	rc := tx.Model(&types.WebhookEventInfo{}).Where("event_key = ?", info.Key).Count(&count)
+	if rc.Error != nil {
+		tx.Rollback()
+		return false, rc.Error
+	}
+	if count > 0 {
+		tx.Rollback()
+		return false, nil
+	}
+
+	rc = tx.Create(&info)
+	if rc.Error != nil {
+		tx.Rollback()
+		return false, rc.Error
+	}
+
+	for _, outbox := range outboxes {
+		rc = tx.Create(&outbox)
+		if rc.Error != nil {
+			tx.Rollback()
+			return false, rc.Error
+		}
+	}
+
+	rc = tx.Commit()
+	return rc.Error == nil, rc.Error
+}
+
+func (cli *MysqlCli) QueryPendingWebhookOutbox(now time.Time, limit int) []types.WebhookOutboxInfo {
+	var infos []types.WebhookOutboxInfo
+
+	cli.db.Where("status = ? and next_time <= ?", OutboxPending, now).
+		Order("next_time").Limit(limit).Find(&infos)
+
+	return infos
+}
+
+// ClaimWebhookOutbox moves next_time of a pending outbox forward so that only one
+// dispatcher delivers it; it returns false if another dispatcher got it first
+func (cli *MysqlCli) ClaimWebhookOutbox(info types.WebhookOutboxInfo, nextTime time.Time) bool {
+	rc := cli.db.Model(&types.WebhookOutboxInfo{}).
+		Where("id = ? and status = ? and next_time = ?", info.Id, OutboxPending, info.NextTime).
+		Update("next_time", nextTime)
+	return rc.Error == nil && rc.RowsAffected == 1
+}
+
+func (cli *MysqlCli) UpdateWebhookOutbox(info types.WebhookOutboxInfo) error {
+	info.ModifyTime = time.Now()
+	rc := cli.db.Save(&info)
+	return rc.Error
+}
+
+func (cli *MysqlCli) QueryReminderInfo(username string, validateDate time.Time, threshold int, notifier string) (*types.ReminderInfo, error) {
+	var info types.ReminderInfo
+	var count int
+
+	cli.db.Where("username = ? and validate_date = ? and threshold = ? and notifier = ?",
+		username, validateDate, threshold, notifier).Find(&info).Count(&count)
+	if count == 0 {
+		return nil, xerrors.Errorf("cannot find any value")
+	}
+
+	return &info, nil
+}
+
+func (cli *MysqlCli) InsertReminderInfo(info types.ReminderInfo) error {
+	rc := cli.db.Create(&info)
+	return rc.Error
+}
+
+// CreateTrialUserInfo creates the user with a trial license, a user can only get
+// one trial even if the user info is removed later
+func (cli *MysqlCli) CreateTrialUserInfo(info types.UserInfo) error {
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	var trial types.TrialInfo
+	var count int
+
+	tx.Where("username = ?", info.Username).Find(&trial).Count(&count)
+	if count > 0 {
+		tx.Rollback()
+		return ErrTrialUsed
+	}
+
+	rc := tx.Create(&types.TrialInfo{
+		Id:         uuid.New(),
+		UserId:     info.Id,
+		Username:   info.Username,
+		CreateTime: time.Now(),
+	})
+	if rc.Error != nil {
+		tx.Rollback()
+		return rc.Error
+	}
+
+	info.Trial = true
+	rc = tx.Create(&info)
+	if rc.Error != nil {
+		tx.Rollback()
+		return rc.Error
+	}
+
+	return tx.Commit().Error
+}
+
+func (cli *MysqlCli) QueryFingerprint(clientId uuid.UUID) (*types.HardwareFingerprint, error) {
+	var info types.FingerprintInfo
+	var count int
+
+	cli.db.Where("client_id = ?", clientId).Find(&info).Count(&count)
+	if count == 0 {
+		return nil, xerrors.Errorf("cannot find fingerprint")
+	}
+
+	fingerprint := types.HardwareFingerprint{}
+	err := json.Unmarshal([]byte(info.FingerprintText), &fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fingerprint, nil
+}
+
+func (cli *MysqlCli) UpdateFingerprint(clientId uuid.UUID, fingerprint types.HardwareFingerprint) error {
+	b, err := json.Marshal(fingerprint)
+	if err != nil {
+		return err
+	}
+
+	rc := cli.db.Save(&types.FingerprintInfo{
+		ClientId:        clientId,
+		FingerprintText: string(b),
+		ModifyTime:      time.Now(),
+	})
+	return rc.Error
+}
+
+func (cli *MysqlCli) UpdateClientSn(id uuid.UUID, sn string) error {
+	rc := cli.db.Model(&types.ClientInfo{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"client_sn":   sn,
+		"modify_time": time.Now(),
+	})
+	return rc.Error
+}
+
+// insertRevocation assigns the next version under the lock of the version counter,
+// so that versions become visible in order and a checker never skips one
+func insertRevocation(tx *gorm.DB, info types.RevocationInfo) error {
+	var counter types.RevocationVersionInfo
+	var count int
+
+	tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", 1).Find(&counter).Count(&count)
+	if count == 0 {
+		return xerrors.Errorf("cannot find revocation version")
+	}
+
+	counter.Version += 1
+	rc := tx.Model(&counter).Update("version", counter.Version)
+	if rc.Error != nil {
+		return rc.Error
+	}
+
+	info.Version = counter.Version
+	return tx.Create(&info).Error
+}
+
+// clearSnRevocation publishes that the sn is not revoked any more when it is
+// registered again by a new client, the revoked client id stays revoked
+func clearSnRevocation(tx *gorm.DB, info types.ClientInfo) error {
+	var last types.RevocationInfo
+	var count int
+
+	tx.Where("client_sn = ?", info.ClientSn).Order("version desc").Limit(1).Find(&last).Count(&count)
+	if count == 0 || !last.Revoked {
+		return nil
+	}
+
+	return insertRevocation(tx, types.RevocationInfo{
+		ClientId:   info.Id,
+		ClientSn:   info.ClientSn,
+		Revoked:    false,
+		CreateTime: time.Now(),
+	})
+}
+
+// SeedRevocations creates the version counter, and fills the revocation list with
+// the clients disabled before the list exists
+func (cli *MysqlCli) SeedRevocations() error {
+	var count int
+
+	cli.db.Model(&types.RevocationVersionInfo{}).Count(&count)
+	if count == 0 {
+		rc := cli.db.Create(&types.RevocationVersionInfo{
+			Id:      1,
+			Version: cli.QueryRevocationVersion(),
+		})
+		if rc.Error != nil {
+			return rc.Error
+		}
+	}
+
+	cli.db.Model(&types.RevocationInfo{}).Count(&count)
+	if count > 0 {
+		return nil
+	}
+
+	var infos []types.ClientInfo
+	cli.db.Where("status = ?", StatusDisable).Find(&infos)
+
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	for _, info := range infos {
+		err := insertRevocation(tx, types.RevocationInfo{
+			ClientId:   info.Id,
+			ClientSn:   info.ClientSn,
+			Revoked:    true,
+			CreateTime: time.Now(),
+		})
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+// QueryRevocations returns the entries after version since. With since 0 only the
+// latest entry of each revoked client is returned, as a full list, without the sn
+// when the sn was registered again after.
+func (cli *MysqlCli) QueryRevocations(since int64) []types.RevocationInfo {
+	var infos []types.RevocationInfo
+
+	cli.db.Where("version > ?", since).Order("version").Find(&infos)
+	if since > 0 {
+		return infos
+	}
+
+	latest := map[uuid.UUID]types.RevocationInfo{}
+	latestSn := map[string]types.RevocationInfo{}
+	for _, info := range infos {
+		latest[info.ClientId] = info
+		latestSn[info.ClientSn] = info
+	}
+
+	revoked := []types.RevocationInfo{}
+	for _, info := range infos {
+		if last := latest[info.ClientId]; last.Version == info.Version && info.Revoked {
+			if !latestSn[info.ClientSn].Revoked {
+				info.ClientSn = ""
+			}
+			revoked = append(revoked, info)
+		}
+	}
+
+	return revoked
+}
+
+func (cli *MysqlCli) QueryRevocationVersion() int64 {
+	var info types.RevocationInfo
+
+	cli.db.Order("version desc").Limit(1).Find(&info)
+
+	return info.Version
+}
+
+func (cli *MysqlCli) InsertActivationCodes(infos []types.ActivationCodeInfo) error {
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	for _, info := range infos {
+		rc := tx.Create(&info)
+		if rc.Error != nil {
+			tx.Rollback()
+			return rc.Error
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+func (cli *MysqlCli) QueryActivationCodes(batchId uuid.UUID) []types.ActivationCodeInfo {
+	var infos []types.ActivationCodeInfo
+
+	db := cli.db
+	if batchId != uuid.Nil {
+		db = db.Where("batch_id = ?", batchId)
+	}
+	db.Order("create_time desc").Find(&infos)
+
+	return infos
+}
+
+// RedeemActivationCode applies the code to the user and marks it redeemed in one
+// transaction, the user info is created if the user has none
+func (cli *MysqlCli) RedeemActivationCode(code string, userId uuid.UUID, username string) (*types.UserInfo, *types.ActivationCodeInfo, error) {
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return nil, nil, tx.Error
+	}
+
+	var codeInfo types.ActivationCodeInfo
+	var count int
+
+	tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("code = ?", code).Find(&codeInfo).Count(&count)
+	if count == 0 {
+		tx.Rollback()
+		return nil, nil, xerrors.Errorf("cannot find activation code")
+	}
+	if codeInfo.RedeemedBy != "" || codeInfo.ExpireTime.Before(time.Now()) {
+		tx.Rollback()
+		return nil, nil, ErrCodeUnavailable
+	}
+
+	var user types.UserInfo
+	count = 0
+
+	tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("id = ?", userId).Find(&user).Count(&count)
+	if count == 0 {
+		user = types.UserInfo{
+			Id:         userId,
+			Username:   username,
+			CreateTime: time.Now(),
+		}
+	}
+
+	start := time.Now()
+	if user.ValidateDate.After(start) {
+		start = user.ValidateDate
+	}
+
+	if codeInfo.PlanId != uuid.Nil {
+		var plan types.PlanInfo
+		count = 0
+
+		tx.Where("id = ?", codeInfo.PlanId).Find(&plan).Count(&count)
+		if count == 0 {
+			tx.Rollback()
+			return nil, nil, xerrors.Errorf("cannot find plan")
+		}
+
+		user.Quota = plan.Quota
+		user.ValidateDate = start.AddDate(0, 0, plan.Duration)
+		user.PlanId = plan.Id
+		user.PlanVersion = plan.Version
+	} else {
+		user.Quota += codeInfo.Quota
+		user.ValidateDate = start.AddDate(0, 0, codeInfo.Days)
+	}
+	user.Trial = false
+	user.ModifyTime = time.Now()
+
+	err := saveUserInfo(tx, user)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	codeInfo.RedeemedBy = username
+	// left null until redeemed, a zero datetime is rejected under NO_ZERO_DATE
+	redeemTime := time.Now()
+	codeInfo.RedeemTime = &redeemTime
+
+	rc := tx.Save(&codeInfo)
+	if rc.Error != nil {
+		tx.Rollback()
+		return nil, nil, rc.Error
+	}
+
+	rc = tx.Commit()
+	if rc.Error != nil {
+		return nil, nil, rc.Error
+	}
+
+	return &user, &codeInfo, nil
+}
+
+// maxCounterEpochs bounds the finished epochs remembered for one counter
+const maxCounterEpochs = 32
+
+func seenEpoch(state types.CounterStateInfo, epoch string) bool {
+	if state.Epoch == epoch {
+		return true
+	}
+	for _, seen := range state.Epochs {
+		if seen == epoch {
+			return true
+		}
+	}
+	return false
+}
+
+// usageDelta applies a counter sample to the stored state. The first sample of a
+// counter is only the baseline. In the current epoch the delta is the growth of
+// the counter and a smaller value is a stale heartbeat. Without epoch a smaller
+// value can only mean the client restarted, so it counts as a reset. An epoch not
+// seen before means the counter was reset so the whole value counts, a late
+// sample from an old epoch is ignored. It returns the delta and whether the state
+// changed.
+func usageDelta(state *types.CounterStateInfo, exist bool, epoch string, value uint64) (uint64, bool) {
+	if !exist {
+		state.Epoch = epoch
+		state.Value = value
+		return 0, true
+	}
+
+	if state.Epoch == epoch {
+		if value < state.Value {
+			if epoch != "" {
+				return 0, false
+			}
+			state.Value = value
+			return value, true
+		}
+		delta := value - state.Value
+		state.Value = value
+		return delta, true
+	}
+
+	if seenEpoch(*state, epoch) {
+		return 0, false
+	}
+
+	state.Epochs = append(state.Epochs, state.Epoch)
+	if maxCounterEpochs < len(state.Epochs) {
+		state.Epochs = state.Epochs[len(state.Epochs)-maxCounterEpochs:]
+	}
+	state.Epoch = epoch
+	state.Value = value
+	return value, true
+}
+
+func parseCounterStateInfo(info *types.CounterStateInfo) {
+	info.Epochs = []string{}
+	if info.EpochsText != "" {
+		json.Unmarshal([]byte(info.EpochsText), &info.Epochs)
+	}
+}
+
+// RecordUsage converts the monotonic counters reported by the client into deltas
+// and adds them to the hourly usage, see usageDelta for the epoch rules
+func (cli *MysqlCli) RecordUsage(info types.ClientInfo, epoch string, counters map[string]uint64, now time.Time) error {
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	hour := now.Truncate(time.Hour)
+
+	for name, value := range counters {
+		var state types.CounterStateInfo
+		var count int
+
+		tx.Set("gorm:query_option", "FOR UPDATE").
+			Where("client_id = ? and name = ?", info.Id, name).Find(&state).Count(&count)
+		parseCounterStateInfo(&state)
+
+		delta, changed := usageDelta(&state, count > 0, epoch, value)
+		if !changed {
+			continue
+		}
+
+		b, _ := json.Marshal(state.Epochs)
+		state.ClientId = info.Id
+		state.Name = name
+		state.EpochsText = string(b)
+		state.ModifyTime = now
+
+		rc := tx.Save(&state)
+		if rc.Error != nil {
+			tx.Rollback()
+			return rc.Error
+		}
+
+		if delta == 0 {
+			continue
+		}
+
+		var usage types.UsageInfo
+		count = 0
+
+		tx.Set("gorm:query_option", "FOR UPDATE").
+			Where("client_id = ? and name = ? and hour = ?", info.Id, name, hour).Find(&usage).Count(&count)
+		if count == 0 {
+			rc = tx.Create(&types.UsageInfo{
+				Id:         uuid.New(),
+				ClientId:   info.Id,
+				ClientUser: info.ClientUser,
+				Name:       name,
+				Hour:       hour,
+				Value:      delta,
+			})
+		} else {
+			rc = tx.Model(&usage).Update("value", gorm.Expr("value + ?", delta))
+		}
+		if rc.Error != nil {
+			tx.Rollback()
+			return rc.Error
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+func (cli *MysqlCli) QueryUsageInfos(username string, start time.Time, end time.Time) []types.UsageInfo {
+	var infos []types.UsageInfo
+
+	cli.db.Where("client_user = ? and hour >= ? and hour < ?", username, start, end).
+		Order("hour").Find(&infos)
+
+	return infos
+}
+
+func (cli *MysqlCli) InsertCommandInfo(info types.CommandInfo) error {
+	rc := cli.db.Create(&info)
+	return rc.Error
+}
+
+// DeliverCommands returns the unexpired commands of the client and of its user
+// which are not delivered yet, or delivered before redeliver ago without an ack,
+// and marks them delivered. Commands of the user only go to clients registered
+// before they are queued.
+func (cli *MysqlCli) DeliverCommands(info types.ClientInfo, now time.Time, redeliver time.Duration) ([]types.CommandInfo, error) {
+	var infos []types.CommandInfo
+
+	cli.db.Where("expire_time > ? and (client_id = ? or (client_id = ? and client_user = ? and create_time >= ?))",
+		now, info.Id, uuid.Nil, info.ClientUser, info.CreateTime).
+		Order("create_time").Find(&infos)
+
+	if len(infos) == 0 {
+		return []types.CommandInfo{}, nil
+	}
+
+	tx := cli.db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	commands := []types.CommandInfo{}
+	for _, command := range infos {
+		var delivery types.CommandDeliveryInfo
+		var count int
+
+		tx.Set("gorm:query_option", "FOR UPDATE").
+			Where("command_id = ? and client_id = ?", command.Id, info.Id).Find(&delivery).Count(&count)
+		if count > 0 && (delivery.Status == CommandAcked || now.Add(-redeliver).Before(delivery.DeliverTime)) {
+			continue
+		}
+
+		var rc *gorm.DB
+		// ack_time is left null until acked, a zero datetime is rejected under
+		// NO_ZERO_DATE
+		if count == 0 {
+			rc = tx.Create(&types.CommandDeliveryInfo{
+				Id:          uuid.New(),
+				CommandId:   command.Id,
+				ClientId:    info.Id,
+				Status:      CommandDelivered,
+				DeliverTime: now,
+			})
+		} else {
+			rc = tx.Model(&delivery).Update("deliver_time", now)
+		}
+		if rc.Error != nil {
+			tx.Rollback()
+			return nil, rc.Error
+		}
+
+		commands = append(commands, command)
+	}
+
+	rc := tx.Commit()
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+
+	return commands, nil
+}
+
+func (cli *MysqlCli) AckCommand(clientId uuid.UUID, ack types.CommandAck) error {
+	rc := cli.db.Model(&types.CommandDeliveryInfo{}).
+		Where("command_id = ? and client_id = ?", ack.Id, clientId).
+		Updates(map[string]interface{}{
+			"status":   CommandAcked,
+			"success":  ack.Success,
+			"result":   ack.Result,
+			"ack_time": time.Now(),
+		})
+	if rc.Error != nil {
+		return rc.Error
+	}
+	if rc.RowsAffected == 0 {
+		return xerrors.Errorf("command is not delivered to client")
+	}
+	return nil
+}
+
+// QueryCommandInfos returns the commands of the user, or only those reaching the
+// client when clientId is set
+func (cli *MysqlCli) QueryCommandInfos(username string, clientId uuid.UUID) []types.CommandInfo {
+	var infos []types.CommandInfo
+
+	db := cli.db.Where("client_user = ?", username)
+	if clientId != uuid.Nil {
+		db = db.Where("client_id in (?, ?)", clientId, uuid.Nil)
+	}
+	db.Order("create_time desc").Find(&infos)
+
+	return infos
+}
+
+func (cli *MysqlCli) QueryCommandDeliveries(commandId uuid.UUID) []types.CommandDeliveryInfo {
+	var infos []types.CommandDeliveryInfo
+
+	cli.db.Where("command_id = ?", commandId).Order("deliver_time").Find(&infos)
+
+	return infos
+}
+
+func (cli *MysqlCli) InsertMaintenanceInfo(info types.MaintenanceInfo) error {
+	b, err := json.Marshal(info.ClientIds)
+	if err != nil {
+		return err
+	}
+	info.ClientIdsText = string(b)
+
+	rc := cli.db.Create(&info)
+	return rc.Error
+}
+
+func parseMaintenanceInfo(info *types.MaintenanceInfo) {
+	info.ClientIds = []uuid.UUID{}
+	if info.ClientIdsText != "" {
+		json.Unmarshal([]byte(info.ClientIdsText), &info.ClientIds)
+	}
+}
+
+func (cli *MysqlCli) QueryMaintenanceInfoById(id uuid.UUID) (*types.MaintenanceInfo, error) {
+	var info types.MaintenanceInfo
+	var count int
+
+	cli.db.Where("id = ?", id).Find(&info).Count(&count)
+	if count == 0 {
+		return nil, xerrors.Errorf("cannot find maintenance")
+	}
+	parseMaintenanceInfo(&info)
+
+	return &info, nil
+}
+
+func (cli *MysqlCli) DeleteMaintenanceInfo(id uuid.UUID) error {
+	rc := cli.db.Where("id = ?", id).Delete(&types.MaintenanceInfo{})
+	return rc.Error
+}
+
+// QueryMaintenanceInfos returns the windows of the user not ended at now
+func (cli *MysqlCli) QueryMaintenanceInfos(username string, now time.Time) []types.MaintenanceInfo {
+	var infos []types.MaintenanceInfo
+
+	cli.db.Where("client_user = ? and end_time > ?", username, now).Order("start_time").Find(&infos)
+	for i := range infos {
+		parseMaintenanceInfo(&infos[i])
+	}
+
+	return infos
+}
+
+// QueryUsersActiveMaintenanceInfos returns the windows open at now of the users
+func (cli *MysqlCli) QueryUsersActiveMaintenanceInfos(usernames []string, now time.Time) []types.MaintenanceInfo {
+	infos := []types.MaintenanceInfo{}
+	if len(usernames) == 0 {
+		return infos
+	}
+
+	cli.db.Where("start_time <= ? and end_time > ? and client_user in (?)", now, now, usernames).Find(&infos)
+	for i := range infos {
+		parseMaintenanceInfo(&infos[i])
+	}
+
+	return infos
+}
+
+// QueryActiveMaintenanceInfos returns the windows open at now, of the user or of
+// all users when username is empty
+func (cli *MysqlCli) QueryActiveMaintenanceInfos(username string, now time.Time) []types.MaintenanceInfo {
+	var infos []types.MaintenanceInfo
+
+	db := cli.db.Where("start_time <= ? and end_time > ?", now, now)
+	if username != "" {
+		db = db.Where("client_user = ?", username)
+	}
+	db.Find(&infos)
+	for i := range infos {
+		parseMaintenanceInfo(&infos[i])
+	}
+
+	return infos
 }
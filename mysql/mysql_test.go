@@ -0,0 +1,96 @@
+package fbcmysql
+
+import (
+	"testing"
+
+	types "github.com/NpoolDevOps/fbc-license-service/types"
+)
+
+type usageSample struct {
+	epoch string
+	value uint64
+	delta uint64
+}
+
+func TestUsageDelta(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []usageSample
+	}{
+		{"first sample is baseline", []usageSample{
+			{"a", 100, 0},
+		}},
+		{"growth in the same epoch", []usageSample{
+			{"a", 100, 0},
+			{"a", 150, 50},
+			{"a", 150, 0},
+			{"a", 160, 10},
+		}},
+		{"stale value in the same epoch", []usageSample{
+			{"a", 100, 0},
+			{"a", 80, 0},
+			{"a", 120, 20},
+		}},
+		{"reset without epoch", []usageSample{
+			{"", 100, 0},
+			{"", 80, 80},
+			{"", 110, 30},
+		}},
+		{"new epoch counts from reset", []usageSample{
+			{"a", 100, 0},
+			{"b", 30, 30},
+			{"b", 50, 20},
+		}},
+		{"late sample of old epoch", []usageSample{
+			{"a", 100, 0},
+			{"b", 30, 30},
+			{"a", 120, 0},
+			{"b", 40, 10},
+		}},
+		{"never back to a seen epoch", []usageSample{
+			{"a", 100, 0},
+			{"b", 30, 30},
+			{"c", 10, 10},
+			{"a", 5, 0},
+			{"b", 50, 0},
+			{"c", 20, 10},
+		}},
+		{"empty epoch is an epoch", []usageSample{
+			{"", 100, 0},
+			{"a", 10, 10},
+			{"", 200, 0},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := types.CounterStateInfo{}
+			exist := false
+
+			for i, sample := range test.samples {
+				delta, _ := usageDelta(&state, exist, sample.epoch, sample.value)
+				exist = true
+				if delta != sample.delta {
+					t.Fatalf("sample %v: delta %v, expect %v", i, delta, sample.delta)
+				}
+			}
+		})
+	}
+}
+
+func TestUsageDeltaEpochs(t *testing.T) {
+	state := types.CounterStateInfo{}
+	exist := false
+
+	for i := 0; i < maxCounterEpochs+10; i++ {
+		usageDelta(&state, exist, string(rune('A'+i)), 1)
+		exist = true
+	}
+
+	if len(state.Epochs) != maxCounterEpochs {
+		t.Fatalf("%v epochs remembered, expect %v", len(state.Epochs), maxCounterEpochs)
+	}
+	if state.Epochs[len(state.Epochs)-1] != string(rune('A'+maxCounterEpochs+8)) {
+		t.Fatalf("latest finished epoch %v is not remembered", state.Epochs[len(state.Epochs)-1])
+	}
+}
@@ -3,6 +3,7 @@ package fbcredis
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	log "github.com/EntropyPool/entropy-logger"
@@ -129,6 +130,8 @@ func (cli *RedisCli) QueryClientExpire(cid uuid.UUID) (bool, error) {
 
 type SessionInfo struct {
 	SessionId    string
+	Spec         string
+	Fingerprint  *types.HardwareFingerprint
 	MyPubKey     string
 	ClientPubKey string
 }
@@ -145,3 +148,212 @@ func (cli *RedisCli) QuerySession(sid uuid.UUID) (*SessionInfo, error) {
 	}
 	return info, nil
 }
+
+// acquireLeaseScript keeps the leases of a user in the zset KEYS[1] scored by
+// their expiry, it drops the expired ones, then renews the caller's lease or
+// takes a new seat when the pool has room.
+var acquireLeaseScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[2])
+local expiry = tonumber(ARGV[2]) + tonumber(ARGV[4])
+if not redis.call('ZSCORE', KEYS[1], ARGV[1]) and
+	redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], expiry, ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return 1
+`)
+
+func leaseKey(username string) string {
+	return fmt.Sprintf("%v:lease:%v", redisKeyPrefix, username)
+}
+
+func (cli *RedisCli) AcquireLease(username string, cid uuid.UUID, quota int, ttl time.Duration) (bool, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	rc, err := acquireLeaseScript.Run(cli.client, []string{leaseKey(username)},
+		cid.String(), now, quota, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return rc == 1, nil
+}
+
+func (cli *RedisCli) ReleaseLease(username string, cid uuid.UUID) error {
+	return cli.client.ZRem(leaseKey(username), cid.String()).Err()
+}
+
+type ClientSessionInfo struct {
+	SessionId uuid.UUID
+}
+
+func (cli *RedisCli) QueryClientSession(cid uuid.UUID) (*ClientSessionInfo, error) {
+	val, err := cli.client.Get(fmt.Sprintf("%v:clientsession:%v", redisKeyPrefix, cid)).Result()
+	if err != nil {
+		return nil, err
+	}
+	info := &ClientSessionInfo{}
+	err = json.Unmarshal([]byte(val), info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// insertClientSessionScript copies the ttl left to the session key, so the
+// client session never outlives the session it points to
+var insertClientSessionScript = redis.NewScript(`
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl == -2 then
+	return 0
+end
+if ttl == -1 then
+	redis.call('SET', KEYS[2], ARGV[1])
+else
+	redis.call('SET', KEYS[2], ARGV[1], 'PX', ttl)
+end
+return 1
+`)
+
+func (cli *RedisCli) InsertClientSession(cid uuid.UUID, sid uuid.UUID) error {
+	b, err := json.Marshal(ClientSessionInfo{
+		SessionId: sid,
+	})
+	if err != nil {
+		return err
+	}
+
+	rc, err := insertClientSessionScript.Run(cli.client, []string{
+		fmt.Sprintf("%v:session:%v", redisKeyPrefix, sid),
+		fmt.Sprintf("%v:clientsession:%v", redisKeyPrefix, cid),
+	}, string(b)).Int()
+	if err != nil {
+		return err
+	}
+	if rc == 0 {
+		return xerrors.Errorf("session is missed")
+	}
+	return nil
+}
+
+// purgeDeviceScript deletes the device key only while it still points to the
+// session, a device which exchanged a new session keeps it
+var purgeDeviceScript = redis.NewScript(`
+local val = redis.call('GET', KEYS[1])
+if val and cjson.decode(val).SessionId == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// PurgeClient removes the client cache together with the sessions of the client
+// and the device keys still pointing to them. The sessions are taken from the
+// client session recorded at login and, since that key may be missing, from the
+// sources of the recent heartbeats.
+func (cli *RedisCli) PurgeClient(cid uuid.UUID) error {
+	keys := []string{
+		fmt.Sprintf("%v:client:%v", redisKeyPrefix, cid),
+		fmt.Sprintf("%v:clientsession:%v", redisKeyPrefix, cid),
+	}
+
+	sessionIds := map[uuid.UUID]struct{}{}
+	clientSession, err := cli.QueryClientSession(cid)
+	if err == nil {
+		sessionIds[clientSession.SessionId] = struct{}{}
+	}
+
+	sources, err := cli.client.ZRange(fmt.Sprintf("%v:source:first:%v", redisKeyPrefix, cid), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	for _, source := range sources {
+		// the source is session@ip
+		if i := strings.Index(source, "@"); 0 <= i {
+			sid, err := uuid.Parse(source[:i])
+			if err == nil {
+				sessionIds[sid] = struct{}{}
+			}
+		}
+	}
+
+	for sid := range sessionIds {
+		keys = append(keys, fmt.Sprintf("%v:session:%v", redisKeyPrefix, sid))
+		session, err := cli.QuerySession(sid)
+		if err != nil || session.Spec == "" {
+			continue
+		}
+		err = purgeDeviceScript.Run(cli.client,
+			[]string{fmt.Sprintf("%v:device:%v", redisKeyPrefix, session.Spec)},
+			sid.String()).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	return cli.client.Del(keys...).Err()
+}
+
+type HeartbeatSource struct {
+	Source string
+	First  time.Time
+	Last   time.Time
+}
+
+// RecordHeartbeatSource records the source of a heartbeat of the client and
+// returns the sources seen inside window, ordered by the time first seen
+func (cli *RedisCli) RecordHeartbeatSource(cid uuid.UUID, source string, window time.Duration) ([]HeartbeatSource, error) {
+	lastKey := fmt.Sprintf("%v:source:last:%v", redisKeyPrefix, cid)
+	firstKey := fmt.Sprintf("%v:source:first:%v", redisKeyPrefix, cid)
+	now := float64(time.Now().Unix())
+	since := fmt.Sprintf("%v", int64(now-window.Seconds()))
+
+	pipe := cli.client.TxPipeline()
+	pipe.ZAdd(lastKey, redis.Z{Score: now, Member: source})
+	pipe.ZAddNX(firstKey, redis.Z{Score: now, Member: source})
+	pipe.ZRemRangeByScore(lastKey, "-inf", "("+since)
+	active := pipe.ZRangeByScoreWithScores(lastKey, redis.ZRangeBy{Min: since, Max: "+inf"})
+	firsts := pipe.ZRangeWithScores(firstKey, 0, -1)
+	pipe.Expire(lastKey, 2*window)
+	pipe.Expire(firstKey, 2*window)
+	_, err := pipe.Exec()
+	if err != nil {
+		return nil, err
+	}
+
+	lasts := map[string]float64{}
+	for _, z := range active.Val() {
+		lasts[fmt.Sprintf("%v", z.Member)] = z.Score
+	}
+
+	sources := []HeartbeatSource{}
+	for _, z := range firsts.Val() {
+		member := fmt.Sprintf("%v", z.Member)
+		if last, ok := lasts[member]; ok {
+			sources = append(sources, HeartbeatSource{
+				Source: member,
+				First:  time.Unix(int64(z.Score), 0),
+				Last:   time.Unix(int64(last), 0),
+			})
+		} else {
+			cli.client.ZRem(firstKey, member)
+		}
+	}
+
+	return sources, nil
+}
+
+type CloneInfo struct {
+	Sources []string
+}
+
+func (cli *RedisCli) QueryClone(cid uuid.UUID) (*CloneInfo, error) {
+	val, err := cli.client.Get(fmt.Sprintf("%v:clone:%v", redisKeyPrefix, cid)).Result()
+	if err != nil {
+		return nil, err
+	}
+	info := &CloneInfo{}
+	err = json.Unmarshal([]byte(val), info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
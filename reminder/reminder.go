@@ -0,0 +1,219 @@
+package reminder
+
+import (
+	"fmt"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/EntropyPool/entropy-logger"
+	fbcmysql "github.com/NpoolDevOps/fbc-license-service/mysql"
+	types "github.com/NpoolDevOps/fbc-license-service/types"
+	"github.com/NpoolDevOps/fbc-license-service/webhook"
+	"github.com/google/uuid"
+)
+
+type SmtpConfig struct {
+	Host   string   `json:"host"`
+	Port   int      `json:"port"`
+	User   string   `json:"user"`
+	Passwd string   `json:"passwd"`
+	From   string   `json:"from"`
+	To     []string `json:"to"`
+}
+
+type ReminderConfig struct {
+	Offsets     []int       `json:"offsets"`
+	ScanMinutes int         `json:"scan_minutes"`
+	Smtp        *SmtpConfig `json:"smtp"`
+}
+
+const (
+	defaultScanMinutes = 60
+	// users expired longer than this are not reminded any more
+	expiredWindow = 24 * time.Hour
+)
+
+var defaultOffsets = []int{30, 7, 1, 0}
+
+type Reminder struct {
+	Username     string    `json:"username"`
+	ValidateDate time.Time `json:"validate_date"`
+	Threshold    int       `json:"threshold"`
+	Quota        int       `json:"quota"`
+	Count        int       `json:"count"`
+}
+
+type Notifier interface {
+	Name() string
+	Notify(reminder Reminder) error
+}
+
+type SmtpNotifier struct {
+	config SmtpConfig
+}
+
+func NewSmtpNotifier(config SmtpConfig) *SmtpNotifier {
+	return &SmtpNotifier{
+		config: config,
+	}
+}
+
+func (n *SmtpNotifier) Name() string {
+	return fmt.Sprintf("smtp:%v", n.config.Host)
+}
+
+func (n *SmtpNotifier) Notify(reminder Reminder) error {
+	subject := fmt.Sprintf("[FBC License] %v expires in %v days", reminder.Username, reminder.Threshold)
+	if reminder.Threshold == 0 {
+		subject = fmt.Sprintf("[FBC License] %v expired", reminder.Username)
+	}
+
+	body := fmt.Sprintf("User: %v\r\nValidate date: %v\r\nQuota: %v\r\nRegistered clients: %v\r\n",
+		reminder.Username, reminder.ValidateDate, reminder.Quota, reminder.Count)
+	msg := fmt.Sprintf("From: %v\r\nTo: %v\r\nSubject: %v\r\n\r\n%v",
+		n.config.From, strings.Join(n.config.To, ","), subject, body)
+
+	var auth smtp.Auth
+	if n.config.User != "" {
+		auth = smtp.PlainAuth("", n.config.User, n.config.Passwd, n.config.Host)
+	}
+
+	return smtp.SendMail(fmt.Sprintf("%v:%v", n.config.Host, n.config.Port),
+		auth, n.config.From, n.config.To, []byte(msg))
+}
+
+// WebhookNotifier puts the reminder in the webhook outbox as a license_reminder
+// event, so it is signed, retried and delivered to the subscribers of the event
+type WebhookNotifier struct {
+	notifier *webhook.Notifier
+}
+
+func NewWebhookNotifier(notifier *webhook.Notifier) *WebhookNotifier {
+	return &WebhookNotifier{
+		notifier: notifier,
+	}
+}
+
+func (n *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+func (n *WebhookNotifier) Notify(reminder Reminder) error {
+	return n.notifier.Emit(types.EventLicenseReminder, reminder)
+}
+
+type Scheduler struct {
+	config      ReminderConfig
+	mysqlClient *fbcmysql.MysqlCli
+	notifiers   []Notifier
+}
+
+func NewScheduler(config ReminderConfig, mysqlCli *fbcmysql.MysqlCli, notifier *webhook.Notifier) *Scheduler {
+	if len(config.Offsets) == 0 {
+		config.Offsets = defaultOffsets
+	}
+	sort.Ints(config.Offsets)
+
+	if config.ScanMinutes <= 0 {
+		config.ScanMinutes = defaultScanMinutes
+	}
+
+	scheduler := &Scheduler{
+		config:      config,
+		mysqlClient: mysqlCli,
+	}
+
+	if config.Smtp != nil && config.Smtp.Host != "" {
+		scheduler.AddNotifier(NewSmtpNotifier(*config.Smtp))
+	}
+	if notifier != nil && notifier.Subscribed(types.EventLicenseReminder) {
+		scheduler.AddNotifier(NewWebhookNotifier(notifier))
+	}
+
+	return scheduler
+}
+
+func (s *Scheduler) AddNotifier(notifier Notifier) {
+	s.notifiers = append(s.notifiers, notifier)
+}
+
+// threshold returns the most urgent offset already reached by the user, or
+// false if the user is not inside any reminder window
+func (s *Scheduler) threshold(validateDate time.Time, now time.Time) (int, bool) {
+	remaining := validateDate.Sub(now)
+	if remaining < -expiredWindow {
+		return 0, false
+	}
+
+	for _, offset := range s.config.Offsets {
+		if remaining <= time.Duration(offset)*24*time.Hour {
+			return offset, true
+		}
+	}
+
+	return 0, false
+}
+
+func (s *Scheduler) scan() {
+	now := time.Now()
+
+	for _, userInfo := range s.mysqlClient.QueryUserInfos() {
+		if userInfo.ValidateDate.IsZero() {
+			continue
+		}
+
+		threshold, ok := s.threshold(userInfo.ValidateDate, now)
+		if !ok {
+			continue
+		}
+
+		reminder := Reminder{
+			Username:     userInfo.Username,
+			ValidateDate: userInfo.ValidateDate,
+			Threshold:    threshold,
+			Quota:        userInfo.Quota,
+			Count:        userInfo.Count,
+		}
+
+		for _, notifier := range s.notifiers {
+			_, err := s.mysqlClient.QueryReminderInfo(userInfo.Username, userInfo.ValidateDate, threshold, notifier.Name())
+			if err == nil {
+				continue
+			}
+
+			err = notifier.Notify(reminder)
+			if err != nil {
+				log.Errorf(log.Fields{}, "fail to remind %v of %v days by %v: %v",
+					userInfo.Username, threshold, notifier.Name(), err)
+				continue
+			}
+
+			err = s.mysqlClient.InsertReminderInfo(types.ReminderInfo{
+				Id:           uuid.New(),
+				Username:     userInfo.Username,
+				ValidateDate: userInfo.ValidateDate,
+				Threshold:    threshold,
+				Notifier:     notifier.Name(),
+				CreateTime:   time.Now(),
+			})
+			if err != nil {
+				log.Errorf(log.Fields{}, "fail to record reminder of %v: %v", userInfo.Username, err)
+			}
+		}
+	}
+}
+
+func (s *Scheduler) Run() {
+	if len(s.notifiers) == 0 {
+		log.Infof(log.Fields{}, "no reminder notifier configured")
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(s.config.ScanMinutes) * time.Minute)
+	for {
+		s.scan()
+		<-ticker.C
+	}
+}
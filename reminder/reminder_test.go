@@ -0,0 +1,67 @@
+package reminder
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThreshold(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	scheduler := NewScheduler(ReminderConfig{}, nil, nil)
+
+	tests := []struct {
+		name         string
+		validateDate time.Time
+		threshold    int
+		ok           bool
+	}{
+		{"far away", now.Add(60 * day), 0, false},
+		{"just outside 30 days", now.Add(30*day + time.Minute), 0, false},
+		{"30 days", now.Add(30 * day), 30, true},
+		{"between 30 and 7 days", now.Add(10 * day), 30, true},
+		{"7 days", now.Add(7 * day), 7, true},
+		{"1 day", now.Add(day), 1, true},
+		{"some hours", now.Add(time.Hour), 1, true},
+		{"expired now", now, 0, true},
+		{"expired recently", now.Add(-time.Hour), 0, true},
+		{"expired long ago", now.Add(-2 * day), 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			threshold, ok := scheduler.threshold(test.validateDate, now)
+			if ok != test.ok || threshold != test.threshold {
+				t.Fatalf("threshold %v %v, expect %v %v", threshold, ok, test.threshold, test.ok)
+			}
+		})
+	}
+}
+
+func TestThresholdOffsets(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	// offsets are sorted so the most urgent one wins
+	scheduler := NewScheduler(ReminderConfig{Offsets: []int{3, 14}}, nil, nil)
+
+	tests := []struct {
+		name         string
+		validateDate time.Time
+		threshold    int
+		ok           bool
+	}{
+		{"outside", now.Add(15 * day), 0, false},
+		{"14 days", now.Add(10 * day), 14, true},
+		{"3 days", now.Add(2 * day), 3, true},
+		{"expired without 0 offset", now.Add(-time.Hour), 3, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			threshold, ok := scheduler.threshold(test.validateDate, now)
+			if ok != test.ok || threshold != test.threshold {
+				t.Fatalf("threshold %v %v, expect %v %v", threshold, ok, test.threshold, test.ok)
+			}
+		})
+	}
+}
@@ -1,13 +1,92 @@
 package types
 
 const (
-	ExchangeKeyAPI      = "/api/v0/client/exchange_key"
-	LoginAPI            = "/api/v0/client/login"
-	HeartbeatAPI        = "/api/v0/client/heartbeat"
-	HeartbeatV1API      = "/api/v1/client/heartbeat"
-	MyClientsAPI        = "/api/v0/client/myclients"
-	UpdateAuthAPI       = "/api/v0/client/update_auth"
-	ClientInfoByIdAPI   = "/api/v0/client/infobyid"
-	ClientInfoBySpecAPI = "/api/v0/client/infobyspec"
-	EtcdHost            = "etcd.npool.top:2379"
+	ExchangeKeyAPI           = "/api/v0/client/exchange_key"
+	LoginAPI                 = "/api/v0/client/login"
+	HeartbeatAPI             = "/api/v0/client/heartbeat"
+	HeartbeatV1API           = "/api/v1/client/heartbeat"
+	MyClientsAPI             = "/api/v0/client/myclients"
+	UpdateAuthAPI            = "/api/v0/client/update_auth"
+	ClientInfoByIdAPI        = "/api/v0/client/infobyid"
+	ClientInfoBySpecAPI      = "/api/v0/client/infobyspec"
+	IssueLicenseAPI          = "/api/v0/client/issue_license"
+	UpdateEntitlementAPI     = "/api/v0/client/update_entitlement"
+	EntitlementsAPI          = "/api/v0/client/entitlements"
+	CreatePlanAPI            = "/api/v0/client/create_plan"
+	UpdatePlanAPI            = "/api/v0/client/update_plan"
+	PlansAPI                 = "/api/v0/client/plans"
+	DeregisterClientAPI      = "/api/v0/client/deregister"
+	UpdateStatusAPI          = "/api/v0/client/update_status"
+	UptimeAPI                = "/api/v0/client/uptime"
+	AuditsAPI                = "/api/v0/client/audits"
+	RevocationsAPI           = "/api/v0/client/revocations"
+	CreateActivationCodesAPI = "/api/v0/client/create_activation_codes"
+	ActivationCodesAPI       = "/api/v0/client/activation_codes"
+	RedeemCodeAPI            = "/api/v0/client/redeem_code"
+	UsageAPI                 = "/api/v0/client/usage"
+	BillingExportAPI         = "/api/v0/client/billing/export"
+	UpdateVersionPolicyAPI   = "/api/v0/client/update_version_policy"
+	VersionPoliciesAPI       = "/api/v0/client/version_policies"
+	QueueCommandAPI          = "/api/v0/client/queue_command"
+	CommandsAPI              = "/api/v0/client/commands"
+	ScheduleMaintenanceAPI   = "/api/v0/client/schedule_maintenance"
+	CancelMaintenanceAPI     = "/api/v0/client/cancel_maintenance"
+	MaintenancesAPI          = "/api/v0/client/maintenances"
+	EtcdHost                 = "etcd.npool.top:2379"
+)
+
+const (
+	WarningNone     = "none"
+	WarningExpiring = "expiring"
+	WarningGrace    = "grace"
+	WarningExpired  = "expired"
+)
+
+// the type of the documents signed by the license key, so that one kind of signed
+// payload is never accepted as another
+const (
+	DocumentOfflineLicense = "offline_license"
+	DocumentRevocationList = "revocation_list"
+)
+
+// compact stop reasons, the v0 heartbeat response only carries these
+const (
+	StopExpired  = "expired"
+	StopDisabled = "disabled"
+	StopClone    = "clone"
+	StopVersion  = "version"
+	StopLease    = "lease"
+)
+
+const (
+	AuditUpdateAuth        = "update_auth"
+	AuditIssueLicense      = "issue_license"
+	AuditUpdateEntitlement = "update_entitlement"
+	AuditCreatePlan        = "create_plan"
+	AuditUpdatePlan        = "update_plan"
+	AuditDeregisterClient  = "deregister_client"
+	AuditUpdateStatus      = "update_status"
+	AuditCreateCodes       = "create_activation_codes"
+	AuditRedeemCode        = "redeem_code"
+	AuditUpdateVersion     = "update_version_policy"
+	AuditQueueCommand      = "queue_command"
+	AuditScheduleMaint     = "schedule_maintenance"
+	AuditCancelMaint       = "cancel_maintenance"
+	AuditRenameClient      = "rename_client"
+)
+
+const (
+	EventClientRegistered = "client_registered"
+	EventClientDisabled   = "client_disabled"
+	EventLicenseExpired   = "license_expired"
+	EventQuotaExhausted   = "quota_exhausted"
+	EventLicenseReminder  = "license_reminder"
+	EventCloneSuspected   = "clone_suspected"
+)
+
+const (
+	CommandRestart            = "restart"
+	CommandReloadConfig       = "reload-config"
+	CommandCollectDiagnostics = "collect-diagnostics"
+	CommandMessage            = "message"
 )
@@ -5,9 +5,18 @@ import (
 	"time"
 )
 
+type HardwareFingerprint struct {
+	BoardSerial string   `json:"board_serial"`
+	ProductUuid string   `json:"product_uuid"`
+	Cpu         string   `json:"cpu"`
+	Macs        []string `json:"macs"`
+	Disks       []string `json:"disks"`
+}
+
 type ExchangeKeyInput struct {
-	Spec      string `json:"spec"`
-	PublicKey string `json:"public_key"`
+	Spec        string               `json:"spec"`
+	PublicKey   string               `json:"public_key"`
+	Fingerprint *HardwareFingerprint `json:"fingerprint,omitempty"`
 }
 
 type ExchangeKeyOutput struct {
@@ -21,10 +30,11 @@ type CommonInput struct {
 
 type ClientLoginInput struct {
 	CommonInput
-	ClientUser   string `json:"client_user"`
-	ClientPasswd string `json:"client_passwd"`
-	ClientSN     string `json:"client_sn"`
-	NetworkType  string `json:"network_type"`
+	ClientUser    string `json:"client_user"`
+	ClientPasswd  string `json:"client_passwd"`
+	ClientSN      string `json:"client_sn"`
+	NetworkType   string `json:"network_type"`
+	ClientVersion string `json:"client_version"`
 }
 
 type ClientLoginOutput struct {
@@ -33,51 +43,439 @@ type ClientLoginOutput struct {
 
 type HeartbeatInput struct {
 	CommonInput
-	ClientUuid uuid.UUID `json:"client_uuid"`
+	ClientUuid    uuid.UUID         `json:"client_uuid"`
+	Counters      map[string]uint64 `json:"counters,omitempty"`
+	CountersEpoch string            `json:"counters_epoch,omitempty"`
+	ClientVersion string            `json:"client_version,omitempty"`
+	CommandAcks   []CommandAck      `json:"command_acks,omitempty"`
 }
 
 type HeartbeatOutput struct {
-	ShouldStop bool `json:"should_stop"`
+	ShouldStop   bool               `json:"should_stop"`
+	ExpireAt     time.Time          `json:"expire_at"`
+	GraceUntil   time.Time          `json:"grace_until"`
+	WarningLevel string             `json:"warning_level"`
+	Entitlements map[string]string  `json:"entitlements,omitempty"`
+	LeaseUntil   time.Time          `json:"lease_until"`
+	StopCode     string             `json:"stop_code,omitempty"`
+	StopReason   string             `json:"stop_reason,omitempty"`
+	UpgradeTo    string             `json:"upgrade_to,omitempty"`
+	Commands     []RemoteCommand    `json:"commands,omitempty"`
+	Maintenance  *MaintenanceWindow `json:"maintenance,omitempty"`
 }
 
 type HeartbeatV1Output HeartbeatOutput
 
+// HeartbeatV0Output is what v0 clients decrypt in one rsa block, so it keeps the
+// legacy fields with a compact stop reason
+type HeartbeatV0Output struct {
+	ShouldStop bool   `json:"should_stop"`
+	StopReason string `json:"stop_reason,omitempty"`
+}
+
 type MyClientsInput struct {
 	AuthCode string `json:"auth_code"`
 }
 
 type ClientInfo struct {
-	Id          uuid.UUID `gorm:"column:id;primary_key" json:"id"`
-	ClientUser  string    `gorm:"column:client_user" json:"client_user"`
-	ClientSn    string    `gorm:"column:client_sn" json:"client_sn"`
-	Status      string    `gorm:"column:status" json:"status"`
+	Id             uuid.UUID `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	ClientUser     string    `gorm:"column:client_user" json:"client_user"`
+	ClientSn       string    `gorm:"column:client_sn" json:"client_sn"`
+	Status         string    `gorm:"column:status" json:"status"`
+	CreateTime     time.Time `gorm:"column:create_time" json:"create_time"`
+	ModifyTime     time.Time `gorm:"column:modify_time" json:"modify_time"`
+	LastSeen       time.Time `gorm:"column:last_seen" json:"last_seen"`
+	NetworkType    string    `gorm:"column:network_type" json:"network_type"`
+	ClientVersion  string    `gorm:"column:client_version" json:"client_version"`
+	CloneSuspected bool      `gorm:"-" json:"clone_suspected"`
+}
+
+type UserInfo struct {
+	Id            uuid.UUID      `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	Username      string         `gorm:"column:username" json:"username"`
+	ValidateDate  time.Time      `gorm:"column:validate_date" json:"validate_date"`
+	Quota         int            `gorm:"column:quota" json:"quota"`
+	Count         int            `gorm:"column:count" json:"count"`
+	PlanId        uuid.UUID      `gorm:"column:plan_id;type:varchar(36)" json:"plan_id"`
+	PlanVersion   int            `gorm:"column:plan_version" json:"plan_version"`
+	LicenseMode   string         `gorm:"column:license_mode" json:"license_mode"`
+	Trial         bool           `gorm:"column:trial" json:"trial"`
+	Reseller      bool           `gorm:"column:reseller" json:"reseller"`
+	ParentId      uuid.UUID      `gorm:"column:parent_id;type:varchar(36);index" json:"parent_id"`
+	NetworkQuotas map[string]int `gorm:"-" json:"network_quotas,omitempty"`
+	CreateTime    time.Time      `gorm:"column:create_time" json:"create_time"`
+	ModifyTime    time.Time      `gorm:"column:modify_time" json:"modify_time"`
+}
+
+type NetworkQuotaInfo struct {
+	Id          uuid.UUID `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	Username    string    `gorm:"column:username;index" json:"username"`
+	NetworkType string    `gorm:"column:network_type" json:"network_type"`
+	Quota       int       `gorm:"column:quota" json:"quota"`
 	CreateTime  time.Time `gorm:"column:create_time" json:"create_time"`
 	ModifyTime  time.Time `gorm:"column:modify_time" json:"modify_time"`
-	NetworkType string    `gorm:"-" json:"network_type"`
 }
 
-type UserInfo struct {
-	Id           uuid.UUID `gorm:"column:id;primary_key" json:"id"`
-	Username     string    `gorm:"column:username" json:"username"`
-	ValidateDate time.Time `gorm:"column:validate_date" json:"validate_date"`
-	Quota        int       `gorm:"column:quota" json:"quota"`
-	Count        int       `gorm:"column:count" json:"count"`
-	CreateTime   time.Time `gorm:"column:create_time" json:"create_time"`
-	ModifyTime   time.Time `gorm:"column:modify_time" json:"modify_time"`
+type NetworkChangeInfo struct {
+	Id         uuid.UUID `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	ClientId   uuid.UUID `gorm:"column:client_id;type:varchar(36);index" json:"client_id"`
+	ClientUser string    `gorm:"column:client_user" json:"client_user"`
+	OldNetwork string    `gorm:"column:old_network" json:"old_network"`
+	NewNetwork string    `gorm:"column:new_network" json:"new_network"`
+	CreateTime time.Time `gorm:"column:create_time" json:"create_time"`
+}
+
+type PlanInfo struct {
+	Id               uuid.UUID         `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	Name             string            `gorm:"column:name;unique_index" json:"name"`
+	Quota            int               `gorm:"column:quota" json:"quota"`
+	Duration         int               `gorm:"column:duration" json:"duration"`
+	Version          int               `gorm:"column:version" json:"version"`
+	EntitlementsText string            `gorm:"column:entitlements;type:text" json:"-"`
+	Entitlements     map[string]string `gorm:"-" json:"entitlements"`
+	CreateTime       time.Time         `gorm:"column:create_time" json:"create_time"`
+	ModifyTime       time.Time         `gorm:"column:modify_time" json:"modify_time"`
+}
+
+type EntitlementInfo struct {
+	Id         uuid.UUID `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	Username   string    `gorm:"column:username" json:"username"`
+	ClientId   uuid.UUID `gorm:"column:client_id;type:varchar(36)" json:"client_id"`
+	Name       string    `gorm:"column:name" json:"name"`
+	Value      string    `gorm:"column:value" json:"value"`
+	PlanId     uuid.UUID `gorm:"column:plan_id;type:varchar(36)" json:"plan_id"`
+	CreateTime time.Time `gorm:"column:create_time" json:"create_time"`
+	ModifyTime time.Time `gorm:"column:modify_time" json:"modify_time"`
 }
 
 type MyClientsOutput struct {
-	SuperUser   bool         `json:"super_user"`
-	VisitorOnly bool         `json:"visitor_only"`
-	Users       []UserInfo   `json:"users"`
-	Clients     []ClientInfo `json:"clients"`
+	SuperUser   bool           `json:"super_user"`
+	VisitorOnly bool           `json:"visitor_only"`
+	Reseller    bool           `json:"reseller"`
+	Users       []UserInfo     `json:"users"`
+	Clients     []ClientInfo   `json:"clients"`
+	Versions    map[string]int `json:"versions"`
 }
 
 type UpdateAuthInput struct {
-	AuthCode     string `json:"auth_code"`
-	Username     string `json:"username"`
-	Quota        int    `json:"quota"`
-	ValidateDate int    `json:"validate_time"`
+	AuthCode      string         `json:"auth_code"`
+	Username      string         `json:"username"`
+	Quota         int            `json:"quota"`
+	ValidateDate  int            `json:"validate_time"`
+	Plan          string         `json:"plan"`
+	LicenseMode   string         `json:"license_mode"`
+	Reseller      *bool          `json:"reseller,omitempty"`
+	Parent        *string        `json:"parent,omitempty"`
+	NetworkQuotas map[string]int `json:"network_quotas,omitempty"`
+}
+
+type CreatePlanInput struct {
+	AuthCode     string            `json:"auth_code"`
+	Name         string            `json:"name"`
+	Quota        int               `json:"quota"`
+	Duration     int               `json:"duration"`
+	Entitlements map[string]string `json:"entitlements"`
+}
+
+// UpdatePlanInput only changes the fields present, an empty entitlements object
+// removes all entitlements of the plan
+type UpdatePlanInput struct {
+	AuthCode     string            `json:"auth_code"`
+	Id           uuid.UUID         `json:"id"`
+	Name         string            `json:"name"`
+	Quota        *int              `json:"quota,omitempty"`
+	Duration     *int              `json:"duration,omitempty"`
+	Entitlements map[string]string `json:"entitlements,omitempty"`
+	Apply        bool              `json:"apply"`
+}
+
+type UpdatePlanOutput struct {
+	PlanInfo
+	FailedUsers []string `json:"failed_users,omitempty"`
+}
+
+type PlansInput struct {
+	AuthCode string `json:"auth_code"`
+}
+
+type PlanDetail struct {
+	PlanInfo
+	Users         []string `json:"users"`
+	OutdatedUsers []string `json:"outdated_users"`
+}
+
+type PlansOutput struct {
+	Plans []PlanDetail `json:"plans"`
+}
+
+type UpdateEntitlementInput struct {
+	AuthCode     string            `json:"auth_code"`
+	Username     string            `json:"username"`
+	ClientId     uuid.UUID         `json:"client_id"`
+	Entitlements map[string]string `json:"entitlements"`
+}
+
+type EntitlementsInput struct {
+	AuthCode string `json:"auth_code"`
+	Username string `json:"username"`
+}
+
+type EntitlementsOutput struct {
+	Entitlements []EntitlementInfo `json:"entitlements"`
+}
+
+type DeregisterClientInput struct {
+	AuthCode string    `json:"auth_code"`
+	ClientId uuid.UUID `json:"client_id"`
+}
+
+type DeregisterInfo struct {
+	Id           uuid.UUID `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	ClientId     uuid.UUID `gorm:"column:client_id;type:varchar(36)" json:"client_id"`
+	ClientUser   string    `gorm:"column:client_user" json:"client_user"`
+	ClientSn     string    `gorm:"column:client_sn" json:"client_sn"`
+	Operator     string    `gorm:"column:operator" json:"operator"`
+	NetworkType  string    `gorm:"column:network_type" json:"network_type"`
+	RegisterTime time.Time `gorm:"column:register_time" json:"register_time"`
+	CreateTime   time.Time `gorm:"column:create_time" json:"create_time"`
+}
+
+type UpdateStatusInput struct {
+	AuthCode  string      `json:"auth_code"`
+	ClientIds []uuid.UUID `json:"client_ids"`
+	Status    string      `json:"status"`
+	Reason    string      `json:"reason"`
+}
+
+type StatusChangeInfo struct {
+	Id         uuid.UUID `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	ClientId   uuid.UUID `gorm:"column:client_id;type:varchar(36)" json:"client_id"`
+	OldStatus  string    `gorm:"column:old_status" json:"old_status"`
+	NewStatus  string    `gorm:"column:new_status" json:"new_status"`
+	Reason     string    `gorm:"column:reason" json:"reason"`
+	Operator   string    `gorm:"column:operator" json:"operator"`
+	CreateTime time.Time `gorm:"column:create_time" json:"create_time"`
+}
+
+type OnlineIntervalInfo struct {
+	Id        uuid.UUID `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	ClientId  uuid.UUID `gorm:"column:client_id;type:varchar(36);index" json:"client_id"`
+	StartTime time.Time `gorm:"column:start_time" json:"start_time"`
+	EndTime   time.Time `gorm:"column:end_time" json:"end_time"`
+}
+
+type UptimeInput struct {
+	AuthCode  string    `json:"auth_code"`
+	ClientId  uuid.UUID `json:"client_id"`
+	Username  string    `json:"username"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+}
+
+type OutageInfo struct {
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+}
+
+type ClientUptime struct {
+	ClientId uuid.UUID    `json:"client_id"`
+	ClientSn string       `json:"client_sn"`
+	Uptime   float64      `json:"uptime"`
+	Outages  []OutageInfo `json:"outages"`
+	LastSeen time.Time    `json:"last_seen"`
+}
+
+type UptimeOutput struct {
+	StartTime time.Time      `json:"start_time"`
+	EndTime   time.Time      `json:"end_time"`
+	Clients   []ClientUptime `json:"clients"`
+}
+
+type AuditInfo struct {
+	Id         uuid.UUID `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	Actor      string    `gorm:"column:actor;index" json:"actor"`
+	ActorId    uuid.UUID `gorm:"column:actor_id;type:varchar(36)" json:"actor_id"`
+	Action     string    `gorm:"column:action" json:"action"`
+	Target     string    `gorm:"column:target;index" json:"target"`
+	Before     string    `gorm:"column:before_value;type:text" json:"before"`
+	After      string    `gorm:"column:after_value;type:text" json:"after"`
+	SourceIp   string    `gorm:"column:source_ip" json:"source_ip"`
+	CreateTime time.Time `gorm:"column:create_time;index" json:"create_time"`
+}
+
+type AuditsInput struct {
+	AuthCode  string    `json:"auth_code"`
+	Actor     string    `json:"actor"`
+	Target    string    `json:"target"`
+	Action    string    `json:"action"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Limit     int       `json:"limit"`
+}
+
+type AuditsOutput struct {
+	Audits []AuditInfo `json:"audits"`
+}
+
+type WebhookEvent struct {
+	Id    uuid.UUID   `json:"id"`
+	Event string      `json:"event"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+type WebhookOutboxInfo struct {
+	Id         uuid.UUID `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	Event      string    `gorm:"column:event" json:"event"`
+	Url        string    `gorm:"column:url" json:"url"`
+	Payload    string    `gorm:"column:payload;type:text" json:"payload"`
+	Status     string    `gorm:"column:status;index" json:"status"`
+	Attempts   int       `gorm:"column:attempts" json:"attempts"`
+	LastError  string    `gorm:"column:last_error;type:text" json:"last_error"`
+	NextTime   time.Time `gorm:"column:next_time;index" json:"next_time"`
+	CreateTime time.Time `gorm:"column:create_time" json:"create_time"`
+	ModifyTime time.Time `gorm:"column:modify_time" json:"modify_time"`
+}
+
+// WebhookEventInfo marks a state transition whose event has been put in the
+// outbox, so that the same transition is never emitted twice
+type WebhookEventInfo struct {
+	Key        string    `gorm:"column:event_key;primary_key;type:varchar(64)" json:"event_key"`
+	Event      string    `gorm:"column:event" json:"event"`
+	CreateTime time.Time `gorm:"column:create_time" json:"create_time"`
+}
+
+type ReminderInfo struct {
+	Id           uuid.UUID `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	Username     string    `gorm:"column:username;unique_index:idx_reminder" json:"username"`
+	ValidateDate time.Time `gorm:"column:validate_date;unique_index:idx_reminder" json:"validate_date"`
+	Threshold    int       `gorm:"column:threshold;unique_index:idx_reminder" json:"threshold"`
+	Notifier     string    `gorm:"column:notifier;unique_index:idx_reminder" json:"notifier"`
+	CreateTime   time.Time `gorm:"column:create_time" json:"create_time"`
+}
+
+type TrialInfo struct {
+	Id         uuid.UUID `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	UserId     uuid.UUID `gorm:"column:user_id;type:varchar(36)" json:"user_id"`
+	Username   string    `gorm:"column:username;unique_index" json:"username"`
+	CreateTime time.Time `gorm:"column:create_time" json:"create_time"`
+}
+
+type FingerprintInfo struct {
+	ClientId        uuid.UUID `gorm:"column:client_id;primary_key;type:varchar(36)" json:"client_id"`
+	FingerprintText string    `gorm:"column:fingerprint;type:text" json:"-"`
+	ModifyTime      time.Time `gorm:"column:modify_time" json:"modify_time"`
+}
+
+type RevocationInfo struct {
+	Version    int64     `gorm:"column:version;primary_key;AUTO_INCREMENT:false" json:"version"`
+	ClientId   uuid.UUID `gorm:"column:client_id;type:varchar(36)" json:"client_id"`
+	ClientSn   string    `gorm:"column:client_sn" json:"client_sn"`
+	Revoked    bool      `gorm:"column:revoked" json:"revoked"`
+	CreateTime time.Time `gorm:"column:create_time" json:"create_time"`
+}
+
+// RevocationVersionInfo is the single row counter of revocation versions
+type RevocationVersionInfo struct {
+	Id      int   `gorm:"column:id;primary_key;AUTO_INCREMENT:false" json:"id"`
+	Version int64 `gorm:"column:version" json:"version"`
+}
+
+type RevocationList struct {
+	Type    string           `json:"type"`
+	Version int64            `json:"version"`
+	Since   int64            `json:"since"`
+	Entries []RevocationInfo `json:"entries"`
+}
+
+type RevocationsInput struct {
+	Since int64 `json:"since"`
+}
+
+type RevocationsOutput struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type ActivationCodeInfo struct {
+	Code       string     `gorm:"column:code;primary_key;type:varchar(32)" json:"code"`
+	BatchId    uuid.UUID  `gorm:"column:batch_id;type:varchar(36);index" json:"batch_id"`
+	Quota      int        `gorm:"column:quota" json:"quota"`
+	Days       int        `gorm:"column:days" json:"days"`
+	PlanId     uuid.UUID  `gorm:"column:plan_id;type:varchar(36)" json:"plan_id"`
+	Creator    string     `gorm:"column:creator" json:"creator"`
+	ExpireTime time.Time  `gorm:"column:expire_time" json:"expire_time"`
+	RedeemedBy string     `gorm:"column:redeemed_by" json:"redeemed_by"`
+	RedeemTime *time.Time `gorm:"column:redeem_time" json:"redeem_time,omitempty"`
+	CreateTime time.Time  `gorm:"column:create_time" json:"create_time"`
+}
+
+type CreateActivationCodesInput struct {
+	AuthCode   string `json:"auth_code"`
+	Count      int    `json:"count"`
+	Quota      int    `json:"quota"`
+	Days       int    `json:"days"`
+	Plan       string `json:"plan"`
+	ExpireDays int    `json:"expire_days"`
+}
+
+type CreateActivationCodesOutput struct {
+	BatchId uuid.UUID `json:"batch_id"`
+	Codes   []string  `json:"codes"`
+}
+
+type ActivationCodesInput struct {
+	AuthCode string    `json:"auth_code"`
+	BatchId  uuid.UUID `json:"batch_id"`
+}
+
+type ActivationCodesOutput struct {
+	Codes []ActivationCodeInfo `json:"codes"`
+}
+
+type RedeemCodeInput struct {
+	AuthCode string `json:"auth_code"`
+	Code     string `json:"code"`
+}
+
+type CounterStateInfo struct {
+	ClientId   uuid.UUID `gorm:"column:client_id;primary_key;type:varchar(36)" json:"client_id"`
+	Name       string    `gorm:"column:name;primary_key;type:varchar(64)" json:"name"`
+	Epoch      string    `gorm:"column:epoch" json:"epoch"`
+	EpochsText string    `gorm:"column:epochs;type:text" json:"-"`
+	Epochs     []string  `gorm:"-" json:"epochs"`
+	Value      uint64    `gorm:"column:value" json:"value"`
+	ModifyTime time.Time `gorm:"column:modify_time" json:"modify_time"`
+}
+
+type UsageInfo struct {
+	Id         uuid.UUID `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	ClientId   uuid.UUID `gorm:"column:client_id;type:varchar(36);unique_index:idx_usage" json:"client_id"`
+	ClientUser string    `gorm:"column:client_user;index" json:"client_user"`
+	Name       string    `gorm:"column:name;type:varchar(64);unique_index:idx_usage" json:"name"`
+	Hour       time.Time `gorm:"column:hour;unique_index:idx_usage" json:"hour"`
+	Value      uint64    `gorm:"column:value" json:"value"`
+}
+
+type UsageInput struct {
+	AuthCode  string    `json:"auth_code"`
+	Username  string    `json:"username"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+}
+
+type ClientUsage struct {
+	ClientId uuid.UUID         `json:"client_id"`
+	ClientSn string            `json:"client_sn"`
+	Counters map[string]uint64 `json:"counters"`
+}
+
+type UsageOutput struct {
+	Username  string            `json:"username"`
+	StartTime time.Time         `json:"start_time"`
+	EndTime   time.Time         `json:"end_time"`
+	Totals    map[string]uint64 `json:"totals"`
+	Clients   []ClientUsage     `json:"clients"`
 }
 
 type ClientInfoBySpecInput struct {
@@ -89,3 +487,204 @@ type ClientInfoByIdInput struct {
 }
 
 type ClientInfoOutput = ClientInfo
+
+type OfflineLicense struct {
+	Type       string    `json:"type"`
+	Id         uuid.UUID `json:"id"`
+	Username   string    `json:"username"`
+	ClientSn   string    `json:"client_sn"`
+	Spec       string    `json:"spec"`
+	Quota      int       `json:"quota"`
+	IssueTime  time.Time `json:"issue_time"`
+	ExpireTime time.Time `json:"expire_time"`
+}
+
+type OfflineLicenseFile struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type IssueLicenseInput struct {
+	AuthCode     string `json:"auth_code"`
+	Username     string `json:"username"`
+	ClientSn     string `json:"client_sn"`
+	Spec         string `json:"spec"`
+	ValidateDate int    `json:"validate_time"`
+}
+
+type IssueLicenseOutput struct {
+	License   OfflineLicenseFile `json:"license"`
+	PublicKey string             `json:"public_key"`
+}
+
+type LicensePeriod struct {
+	StartTime    time.Time `json:"start_time"`
+	ValidateDate time.Time `json:"validate_date"`
+	Quota        int       `json:"quota"`
+	PlanId       uuid.UUID `json:"plan_id"`
+}
+
+type QuotaChange struct {
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Action   string    `json:"action"`
+	OldQuota int       `json:"old_quota"`
+	NewQuota int       `json:"new_quota"`
+}
+
+type BillingStatement struct {
+	Username       string             `json:"username"`
+	StartTime      time.Time          `json:"start_time"`
+	EndTime        time.Time          `json:"end_time"`
+	Quota          int                `json:"quota"`
+	ValidateDate   time.Time          `json:"validate_date"`
+	SeatDays       map[string]float64 `json:"seat_days"`
+	LicensePeriods []LicensePeriod    `json:"license_periods"`
+	QuotaChanges   []QuotaChange      `json:"quota_changes"`
+}
+
+type BillingExportInput struct {
+	AuthCode  string    `json:"auth_code"`
+	Username  string    `json:"username"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Format    string    `json:"format"`
+}
+
+type BillingExportOutput struct {
+	Format     string             `json:"format"`
+	Statements []BillingStatement `json:"statements,omitempty"`
+	Csv        string             `json:"csv,omitempty"`
+}
+
+type VersionPolicyInfo struct {
+	Id          uuid.UUID `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	Username    string    `gorm:"column:username;index" json:"username"`
+	NetworkType string    `gorm:"column:network_type" json:"network_type"`
+	MinVersion  string    `gorm:"column:min_version" json:"min_version"`
+	BlockedText string    `gorm:"column:blocked;type:text" json:"-"`
+	Blocked     []string  `gorm:"-" json:"blocked"`
+	Recommended string    `gorm:"column:recommended" json:"recommended"`
+	CreateTime  time.Time `gorm:"column:create_time" json:"create_time"`
+	ModifyTime  time.Time `gorm:"column:modify_time" json:"modify_time"`
+}
+
+type UpdateVersionPolicyInput struct {
+	AuthCode    string   `json:"auth_code"`
+	Username    string   `json:"username"`
+	NetworkType string   `json:"network_type"`
+	MinVersion  string   `json:"min_version"`
+	Blocked     []string `json:"blocked"`
+	Recommended string   `json:"recommended"`
+	Remove      bool     `json:"remove"`
+}
+
+type VersionPoliciesInput struct {
+	AuthCode string `json:"auth_code"`
+}
+
+type VersionPoliciesOutput struct {
+	Policies []VersionPolicyInfo `json:"policies"`
+}
+
+type CommandInfo struct {
+	Id         uuid.UUID `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	ClientUser string    `gorm:"column:client_user;index" json:"client_user"`
+	ClientId   uuid.UUID `gorm:"column:client_id;type:varchar(36);index" json:"client_id"`
+	Command    string    `gorm:"column:command" json:"command"`
+	Payload    string    `gorm:"column:payload;type:text" json:"payload"`
+	Operator   string    `gorm:"column:operator" json:"operator"`
+	ExpireTime time.Time `gorm:"column:expire_time" json:"expire_time"`
+	CreateTime time.Time `gorm:"column:create_time" json:"create_time"`
+}
+
+type CommandDeliveryInfo struct {
+	Id          uuid.UUID  `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	CommandId   uuid.UUID  `gorm:"column:command_id;type:varchar(36);unique_index:idx_command_client" json:"command_id"`
+	ClientId    uuid.UUID  `gorm:"column:client_id;type:varchar(36);unique_index:idx_command_client" json:"client_id"`
+	Status      string     `gorm:"column:status" json:"status"`
+	Success     bool       `gorm:"column:success" json:"success"`
+	Result      string     `gorm:"column:result;type:text" json:"result"`
+	DeliverTime time.Time  `gorm:"column:deliver_time" json:"deliver_time"`
+	AckTime     *time.Time `gorm:"column:ack_time" json:"ack_time,omitempty"`
+}
+
+type RemoteCommand struct {
+	Id         uuid.UUID `json:"id"`
+	Command    string    `json:"command"`
+	Payload    string    `json:"payload,omitempty"`
+	ExpireTime time.Time `json:"expire_time"`
+}
+
+type CommandAck struct {
+	Id      uuid.UUID `json:"id"`
+	Success bool      `json:"success"`
+	Result  string    `json:"result,omitempty"`
+}
+
+type QueueCommandInput struct {
+	AuthCode      string    `json:"auth_code"`
+	Username      string    `json:"username"`
+	ClientId      uuid.UUID `json:"client_id"`
+	Command       string    `json:"command"`
+	Payload       string    `json:"payload"`
+	ExpireMinutes int       `json:"expire_minutes"`
+}
+
+type CommandsInput struct {
+	AuthCode string    `json:"auth_code"`
+	Username string    `json:"username"`
+	ClientId uuid.UUID `json:"client_id"`
+}
+
+type CommandDetail struct {
+	CommandInfo
+	Expired    bool                  `json:"expired"`
+	Deliveries []CommandDeliveryInfo `json:"deliveries"`
+}
+
+type CommandsOutput struct {
+	Commands []CommandDetail `json:"commands"`
+}
+
+type MaintenanceInfo struct {
+	Id            uuid.UUID   `gorm:"column:id;primary_key;type:varchar(36)" json:"id"`
+	ClientUser    string      `gorm:"column:client_user;index" json:"client_user"`
+	ClientIdsText string      `gorm:"column:client_ids;type:text" json:"-"`
+	ClientIds     []uuid.UUID `gorm:"-" json:"client_ids"`
+	StartTime     time.Time   `gorm:"column:start_time" json:"start_time"`
+	EndTime       time.Time   `gorm:"column:end_time;index" json:"end_time"`
+	Reason        string      `gorm:"column:reason" json:"reason"`
+	Operator      string      `gorm:"column:operator" json:"operator"`
+	CreateTime    time.Time   `gorm:"column:create_time" json:"create_time"`
+}
+
+type MaintenanceWindow struct {
+	Id        uuid.UUID `json:"id"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+type ScheduleMaintenanceInput struct {
+	AuthCode  string      `json:"auth_code"`
+	Username  string      `json:"username"`
+	ClientIds []uuid.UUID `json:"client_ids"`
+	StartTime time.Time   `json:"start_time"`
+	EndTime   time.Time   `json:"end_time"`
+	Reason    string      `json:"reason"`
+}
+
+type CancelMaintenanceInput struct {
+	AuthCode string    `json:"auth_code"`
+	Id       uuid.UUID `json:"id"`
+}
+
+type MaintenancesInput struct {
+	AuthCode string `json:"auth_code"`
+	Username string `json:"username"`
+}
+
+type MaintenancesOutput struct {
+	Maintenances []MaintenanceInfo `json:"maintenances"`
+}
@@ -0,0 +1,94 @@
+package version
+
+import (
+	"strconv"
+	"strings"
+)
+
+// split parses the version into its release parts and its pre-release parts,
+// build metadata after "+" is ignored
+func split(version string) ([]string, []string) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.Index(version, "+"); 0 <= i {
+		version = version[:i]
+	}
+	if version == "" {
+		return nil, nil
+	}
+
+	var pre []string
+	if i := strings.Index(version, "-"); 0 <= i {
+		pre = strings.Split(version[i+1:], ".")
+		version = version[:i]
+	}
+
+	parts := strings.Split(version, ".")
+	// 1.2 and 1.2.0 are the same version
+	for 1 < len(parts) && parts[len(parts)-1] == "0" {
+		parts = parts[:len(parts)-1]
+	}
+	return parts, pre
+}
+
+// compareParts compares the parts one by one, numeric parts are compared as
+// numbers and sort below the others which are compared as strings
+func compareParts(pa []string, pb []string) int {
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		if len(pa) <= i {
+			return -1
+		}
+		if len(pb) <= i {
+			return 1
+		}
+
+		na, erra := strconv.ParseUint(pa[i], 10, 64)
+		nb, errb := strconv.ParseUint(pb[i], 10, 64)
+		switch {
+		case erra == nil && errb == nil:
+			if na < nb {
+				return -1
+			}
+			if na > nb {
+				return 1
+			}
+		case erra == nil:
+			return -1
+		case errb == nil:
+			return 1
+		default:
+			if c := strings.Compare(pa[i], pb[i]); c != 0 {
+				return c
+			}
+		}
+	}
+
+	return 0
+}
+
+// Compare returns -1, 0 or 1 when a is older than, equal to or newer than b.
+// Versions are ordered like semver: a pre-release such as "1.2.0-rc1" is older
+// than "1.2.0", and an empty version is older than any other one.
+func Compare(a string, b string) int {
+	pa, prea := split(a)
+	pb, preb := split(b)
+
+	if c := compareParts(pa, pb); c != 0 {
+		return c
+	}
+
+	if len(prea) == 0 && len(preb) == 0 {
+		return 0
+	}
+	if len(prea) == 0 {
+		return 1
+	}
+	if len(preb) == 0 {
+		return -1
+	}
+	return compareParts(prea, preb)
+}
+
+// Equal reports whether a and b are the same version, so "v1.2" equals "1.2"
+func Equal(a string, b string) bool {
+	return Compare(a, b) == 0
+}
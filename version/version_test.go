@@ -0,0 +1,70 @@
+package version
+
+import (
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a string
+		b string
+		c int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2", "1.2.0", 0},
+		{"1.2.0.0", "1.2", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"2.0", "1.99.99", 1},
+		{"1.2.1", "1.2", 1},
+		{"", "0.0.1", -1},
+		{"", "", 0},
+		{"1.2.0-rc1", "1.2.0", -1},
+		{"1.2.0-rc1", "1.2", -1},
+		{"1.2.0-rc1", "1.1.9", 1},
+		{"1.2.0-rc.1", "1.2.0-rc.2", -1},
+		{"1.2.0-rc.2", "1.2.0-rc.10", -1},
+		{"1.2.0-alpha", "1.2.0-beta", -1},
+		{"1.2.0-alpha", "1.2.0-alpha.1", -1},
+		{"1.2.0-1", "1.2.0-alpha", -1},
+		{"1.2.0+build1", "1.2.0+build2", 0},
+		{"1.2.0-rc1+build", "1.2.0-rc1", 0},
+		{"1.2.x", "1.2.9", 1},
+		{"1.2.9", "1.2.x", -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.a+" "+test.b, func(t *testing.T) {
+			if c := Compare(test.a, test.b); c != test.c {
+				t.Fatalf("compare %q %q returns %v, expect %v", test.a, test.b, c, test.c)
+			}
+			if c := Compare(test.b, test.a); c != -test.c {
+				t.Fatalf("compare %q %q returns %v, expect %v", test.b, test.a, c, -test.c)
+			}
+		})
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a     string
+		b     string
+		equal bool
+	}{
+		{"v1.2", "1.2", true},
+		{" 1.2.0 ", "1.2", true},
+		{"1.2.0+build", "1.2", true},
+		{"1.2.0-rc1", "1.2", false},
+		{"1.2", "1.3", false},
+		{"", "1.2", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.a+" "+test.b, func(t *testing.T) {
+			if equal := Equal(test.a, test.b); equal != test.equal {
+				t.Fatalf("equal %q %q returns %v, expect %v", test.a, test.b, equal, test.equal)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	log "github.com/EntropyPool/entropy-logger"
+	"github.com/NpoolDevOps/fbc-license-service/crypto"
+	fbcmysql "github.com/NpoolDevOps/fbc-license-service/mysql"
+	types "github.com/NpoolDevOps/fbc-license-service/types"
+	httpdaemon "github.com/NpoolRD/http-daemon"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+type Subscriber struct {
+	Event  string `json:"event"`
+	Url    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+type WebhookConfig struct {
+	Subscribers []Subscriber `json:"subscribers"`
+	MaxAttempts int          `json:"max_attempts"`
+}
+
+const (
+	defaultMaxAttempts = 10
+	dispatchInterval   = 5 * time.Second
+	dispatchBatch      = 100
+	claimDuration      = 2 * time.Minute
+	minBackoff         = 10 * time.Second
+	maxBackoff         = time.Hour
+
+	SignatureHeader = "X-FBC-Signature"
+	EventHeader     = "X-FBC-Event"
+)
+
+type Notifier struct {
+	config      WebhookConfig
+	mysqlClient *fbcmysql.MysqlCli
+}
+
+func NewNotifier(config WebhookConfig, mysqlCli *fbcmysql.MysqlCli) *Notifier {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultMaxAttempts
+	}
+	return &Notifier{
+		config:      config,
+		mysqlClient: mysqlCli,
+	}
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of payload, subscribers should compare
+// it with the X-FBC-Signature header
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// outboxes builds the outbox of the event for every subscriber of the event
+func (n *Notifier) outboxes(event string, data interface{}) ([]types.WebhookOutboxInfo, error) {
+	payload, err := json.Marshal(types.WebhookEvent{
+		Id:    uuid.New(),
+		Event: event,
+		Time:  time.Now(),
+		Data:  data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	outboxes := []types.WebhookOutboxInfo{}
+	for _, subscriber := range n.config.Subscribers {
+		if subscriber.Event != event {
+			continue
+		}
+		outboxes = append(outboxes, types.WebhookOutboxInfo{
+			Id:         uuid.New(),
+			Event:      event,
+			Url:        subscriber.Url,
+			Payload:    string(payload),
+			Status:     fbcmysql.OutboxPending,
+			NextTime:   time.Now(),
+			CreateTime: time.Now(),
+			ModifyTime: time.Now(),
+		})
+	}
+
+	return outboxes, nil
+}
+
+func (n *Notifier) insert(outboxes []types.WebhookOutboxInfo) error {
+	var insertErr error
+	for _, outbox := range outboxes {
+		err := n.mysqlClient.InsertWebhookOutbox(outbox)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to insert outbox of %v to %v: %v", outbox.Event, outbox.Url, err)
+			insertErr = err
+		}
+	}
+	return insertErr
+}
+
+// Subscribed reports whether any subscriber listens to the event
+func (n *Notifier) Subscribed(event string) bool {
+	for _, subscriber := range n.config.Subscribers {
+		if subscriber.Event == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Emit stores the event in the outbox for every subscriber of the event, the
+// delivery is done later by Run
+func (n *Notifier) Emit(event string, data interface{}) error {
+	outboxes, err := n.outboxes(event, data)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to marshal event %v: %v", event, err)
+		return err
+	}
+	return n.insert(outboxes)
+}
+
+// EmitOnce emits the event only for the first time the transition identified by
+// key is seen, retrying logins and heartbeats of the same state send nothing.
+// If the mark cannot be stored the event is still emitted, a duplicate is
+// better than a lost event.
+func (n *Notifier) EmitOnce(event string, key string, data interface{}) {
+	outboxes, err := n.outboxes(event, data)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to marshal event %v: %v", event, err)
+		return
+	}
+
+	_, err = n.mysqlClient.InsertWebhookEvent(types.WebhookEventInfo{
+		Key:        crypto.Digest(event + ":" + key),
+		Event:      event,
+		CreateTime: time.Now(),
+	}, outboxes)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to mark event %v of %v: %v", event, key, err)
+		n.insert(outboxes)
+	}
+}
+
+func (n *Notifier) subscriber(event string, url string) *Subscriber {
+	for _, subscriber := range n.config.Subscribers {
+		if subscriber.Event == event && subscriber.Url == url {
+			return &subscriber
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) deliver(info types.WebhookOutboxInfo) error {
+	subscriber := n.subscriber(info.Event, info.Url)
+	if subscriber == nil {
+		return xerrors.Errorf("subscriber is removed")
+	}
+
+	resp, err := httpdaemon.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader(EventHeader, info.Event).
+		SetHeader(SignatureHeader, Sign(subscriber.Secret, []byte(info.Payload))).
+		SetBody([]byte(info.Payload)).
+		Post(info.Url)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() < 200 || 300 <= resp.StatusCode() {
+		return xerrors.Errorf("NON-2xx return %v", resp.StatusCode())
+	}
+
+	return nil
+}
+
+func backoff(attempts int) time.Duration {
+	delay := minBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// result updates the outbox after an attempt to deliver it, err is the error
+// of the attempt
+func (n *Notifier) result(info types.WebhookOutboxInfo, err error, now time.Time) types.WebhookOutboxInfo {
+	info.Attempts += 1
+	if err == nil {
+		info.Status = fbcmysql.OutboxDelivered
+		info.LastError = ""
+		return info
+	}
+
+	info.LastError = err.Error()
+	info.NextTime = now.Add(backoff(info.Attempts))
+	if n.config.MaxAttempts <= info.Attempts {
+		info.Status = fbcmysql.OutboxFailed
+	}
+	return info
+}
+
+func (n *Notifier) dispatch() {
+	for _, info := range n.mysqlClient.QueryPendingWebhookOutbox(time.Now(), dispatchBatch) {
+		if !n.mysqlClient.ClaimWebhookOutbox(info, time.Now().Add(claimDuration)) {
+			continue
+		}
+
+		err := n.deliver(info)
+		info = n.result(info, err, time.Now())
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to deliver %v to %v [%v]: %v", info.Event, info.Url, info.Attempts, err)
+		}
+
+		err = n.mysqlClient.UpdateWebhookOutbox(info)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to update outbox %v: %v", info.Id, err)
+		}
+	}
+}
+
+func (n *Notifier) Run() {
+	ticker := time.NewTicker(dispatchInterval)
+	for range ticker.C {
+		n.dispatch()
+	}
+}
@@ -0,0 +1,99 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+
+	fbcmysql "github.com/NpoolDevOps/fbc-license-service/mysql"
+	types "github.com/NpoolDevOps/fbc-license-service/types"
+	"golang.org/x/xerrors"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		payload   string
+		signature string
+	}{
+		{"rfc 4231", "Jefe", "what do ya want for nothing?",
+			"5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"},
+		{"empty", "", "",
+			"b613679a0814d9ec772f95d778c35fc5ff1697c493715653c6c712144292c5ad"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signature := Sign(test.secret, []byte(test.payload))
+			if signature != test.signature {
+				t.Fatalf("signature is %v, expect %v", signature, test.signature)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{0, minBackoff},
+		{1, minBackoff},
+		{2, 2 * minBackoff},
+		{3, 4 * minBackoff},
+		{9, 256 * minBackoff},
+		{10, maxBackoff},
+		{100, maxBackoff},
+	}
+
+	for _, test := range tests {
+		delay := backoff(test.attempts)
+		if delay != test.delay {
+			t.Fatalf("backoff of %v attempts is %v, expect %v", test.attempts, delay, test.delay)
+		}
+	}
+}
+
+func TestResult(t *testing.T) {
+	notifier := NewNotifier(WebhookConfig{MaxAttempts: 3}, nil)
+	now := time.Now()
+	fail := xerrors.Errorf("NON-2xx return 500")
+
+	tests := []struct {
+		name      string
+		attempts  int
+		err       error
+		status    string
+		nextTime  time.Time
+		lastError string
+	}{
+		{"delivered", 0, nil, fbcmysql.OutboxDelivered, now, ""},
+		{"delivered after retry", 2, nil, fbcmysql.OutboxDelivered, now, ""},
+		{"first failure", 0, fail, fbcmysql.OutboxPending, now.Add(minBackoff), fail.Error()},
+		{"second failure", 1, fail, fbcmysql.OutboxPending, now.Add(2 * minBackoff), fail.Error()},
+		{"last failure", 2, fail, fbcmysql.OutboxFailed, now.Add(4 * minBackoff), fail.Error()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info := notifier.result(types.WebhookOutboxInfo{
+				Status:    fbcmysql.OutboxPending,
+				Attempts:  test.attempts,
+				LastError: "previous error",
+				NextTime:  now,
+			}, test.err, now)
+			if info.Attempts != test.attempts+1 {
+				t.Fatalf("attempts is %v, expect %v", info.Attempts, test.attempts+1)
+			}
+			if info.Status != test.status {
+				t.Fatalf("status is %v, expect %v", info.Status, test.status)
+			}
+			if !info.NextTime.Equal(test.nextTime) {
+				t.Fatalf("next time is %v, expect %v", info.NextTime, test.nextTime)
+			}
+			if info.LastError != test.lastError {
+				t.Fatalf("last error is %q, expect %q", info.LastError, test.lastError)
+			}
+		})
+	}
+}